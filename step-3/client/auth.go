@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"sync"
+	"time"
+)
+
+// Credentials supply the bearer token sent with every request.
+type Credentials interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a token that never changes, such as an api key.
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// RefreshingToken caches the token of Refresh and fetches a new one
+// Margin before it expires. A zero expiry keeps the token until Invalidate.
+type RefreshingToken struct {
+	Refresh func(ctx context.Context) (token string, expiry time.Time, err error)
+	Margin  time.Duration
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func (t *RefreshingToken) Token(ctx context.Context) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.token != "" && (t.expiry.IsZero() || time.Now().Add(t.Margin).Before(t.expiry)) {
+		return t.token, nil
+	}
+	token, expiry, err := t.Refresh(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.token = token
+	t.expiry = expiry
+	return token, nil
+}
+
+// Invalidate drops the cached token, the next call refreshes it.
+func (t *RefreshingToken) Invalidate() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.token = ""
+}
+
+// withMetaData returns ctx with a copy of its metadata where key is set to value.
+func withMetaData(ctx context.Context, key, value string) context.Context {
+	meta := make(map[string]string)
+	if metaData, ok := ctx.Value(protocol.MetaDataKey).(map[string]string); ok {
+		for k, v := range metaData {
+			meta[k] = v
+		}
+	}
+	meta[key] = value
+	return context.WithValue(ctx, protocol.MetaDataKey, meta)
+}
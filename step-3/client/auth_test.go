@@ -0,0 +1,33 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func TestCredentials(t *testing.T) {
+	so := server.DefaultOption
+	so.Authenticator = server.TokenAuthenticator{"secret": {Name: "alice"}}
+	addr := client.Serve(t, Arith{}, so)
+	for token, code := range map[client.StaticToken]protocol.StatusCode{"secret": rpcerr.OK, "guess": rpcerr.Unauthenticated} {
+		option := client.DefaultOption
+		option.Credentials = token
+		c, err := client.NewSimpleClient("tcp", addr, option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := c.Call(context.Background(), "Arith.Add", Args{}, &Reply{}); rpcerr.Code(err) != code {
+			t.Fatalf("Call with %s: %v", token, err)
+		}
+		call := <-c.Go(context.Background(), "Arith.Add", Args{}, &Reply{}, nil).Done
+		if rpcerr.Code(call.Error) != code {
+			t.Fatalf("Go with %s: %v", token, call.Error)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"errors"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrorCircuitOpen = rpcerr.New(rpcerr.Unavailable, "rpc-client: circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerPolicy opens the breaker of an instance after ConsecutiveFailures failures in a row,
+// or when at least MinRequests requests within Window failed at FailureRatio. After OpenTimeout
+// up to HalfOpenProbes probe requests are let through, and as many successes close it again.
+// Only transport failures and timeouts count, errors returned by handlers do not.
+type BreakerPolicy struct {
+	ConsecutiveFailures int
+	FailureRatio        float64
+	MinRequests         int
+	Window              time.Duration
+	OpenTimeout         time.Duration
+	HalfOpenProbes      int
+}
+
+func (p BreakerPolicy) enabled() bool {
+	return p.ConsecutiveFailures > 0 || p.FailureRatio > 0
+}
+
+type breaker struct {
+	policy      BreakerPolicy
+	name        string
+	mutex       sync.Mutex
+	state       BreakerState
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probes      int
+	successes   int
+	// generation changes with the state, results of requests allowed in an
+	// earlier generation say nothing about the current one
+	generation uint64
+	logger     logging.Logger
+}
+
+func newBreaker(name string, policy BreakerPolicy, logger logging.Logger) *breaker {
+	if policy.HalfOpenProbes < 1 {
+		policy.HalfOpenProbes = 1
+	}
+	return &breaker{name: name, policy: policy, windowStart: time.Now(), logger: logger}
+}
+
+func (b *breaker) State() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// ready reports whether allow would let a request through, without taking a probe.
+func (b *breaker) ready() bool {
+	if !b.policy.enabled() {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		return time.Since(b.openedAt) >= b.policy.OpenTimeout
+	case BreakerHalfOpen:
+		return b.probes < b.policy.HalfOpenProbes
+	}
+	return true
+}
+
+// allow returns the generation to pass to record with the result of the request.
+func (b *breaker) allow() (uint64, bool) {
+	if !b.policy.enabled() {
+		return 0, true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.policy.OpenTimeout {
+		b.setState(BreakerHalfOpen)
+		b.probes = 0
+		b.successes = 0
+	}
+	switch b.state {
+	case BreakerOpen:
+		return b.generation, false
+	case BreakerHalfOpen:
+		if b.probes >= b.policy.HalfOpenProbes {
+			return b.generation, false
+		}
+		b.probes++
+	}
+	return b.generation, true
+}
+
+func (b *breaker) record(generation uint64, err error) {
+	if !b.policy.enabled() {
+		return
+	}
+	failed := isFailure(err)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if generation != b.generation {
+		return
+	}
+	if Canceled(err) {
+		if b.state == BreakerHalfOpen {
+			b.probes--
+		}
+		return
+	}
+	switch b.state {
+	case BreakerHalfOpen:
+		b.probes--
+		if failed {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.policy.HalfOpenProbes {
+			b.setState(BreakerClosed)
+			b.reset()
+		}
+	case BreakerClosed:
+		if b.policy.Window > 0 && time.Since(b.windowStart) > b.policy.Window {
+			b.reset()
+		}
+		b.requests++
+		if !failed {
+			b.consecutive = 0
+			return
+		}
+		b.failures++
+		b.consecutive++
+		if b.policy.ConsecutiveFailures > 0 && b.consecutive >= b.policy.ConsecutiveFailures {
+			b.open()
+			return
+		}
+		if b.policy.FailureRatio > 0 && b.requests >= b.policy.MinRequests &&
+			float64(b.failures)/float64(b.requests) >= b.policy.FailureRatio {
+			b.open()
+		}
+	}
+}
+
+// open must be called with b.mutex held.
+func (b *breaker) open() {
+	b.setState(BreakerOpen)
+	b.openedAt = time.Now()
+	b.reset()
+}
+
+// reset must be called with b.mutex held.
+func (b *breaker) reset() {
+	b.consecutive = 0
+	b.requests = 0
+	b.failures = 0
+	b.windowStart = time.Now()
+}
+
+// setState must be called with b.mutex held.
+func (b *breaker) setState(state BreakerState) {
+	if b.state != state {
+		b.logger.Warn("rpc-client: circuit breaker changed state", logging.KeyAddr, b.name, "state", state.String())
+		b.generation++
+	}
+	b.state = state
+}
+
+// isFailure tells the failures of an instance from errors returned by its handlers.
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == ErrorTimeout || err == ErrorConnection || NotSent(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+)
+
+// do lets a request with result err through b.
+func do(b *breaker, err error) bool {
+	generation, ok := b.allow()
+	if ok {
+		b.record(generation, err)
+	}
+	return ok
+}
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	b := newBreaker("test", BreakerPolicy{ConsecutiveFailures: 3, OpenTimeout: time.Hour}, logging.Or(nil))
+	// errors returned by handlers are not failures of the instance
+	for _, err := range []error{ErrorTimeout, ErrorConnection, nil, ErrorTimeout, rpcerr.New(rpcerr.Internal, "handler failed"),
+		ErrorTimeout, errors.New("handler failed"), ErrorTimeout, ErrorTimeout} {
+		do(b, err)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("state %s after 2 failures in a row, want closed", b.State())
+	}
+	do(b, ErrorTimeout)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state %s after 3 failures in a row, want open", b.State())
+	}
+	if do(b, nil) || b.ready() {
+		t.Fatal("an open breaker lets requests through")
+	}
+}
+
+func TestBreakerFailureRatio(t *testing.T) {
+	b := newBreaker("test", BreakerPolicy{FailureRatio: 0.5, MinRequests: 4, Window: time.Hour, OpenTimeout: time.Hour}, logging.Or(nil))
+	do(b, ErrorTimeout)
+	do(b, nil)
+	do(b, nil)
+	if b.State() != BreakerClosed {
+		t.Fatalf("state %s below MinRequests, want closed", b.State())
+	}
+	do(b, ErrorTimeout)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state %s at half of the requests failed, want open", b.State())
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b := newBreaker("test", BreakerPolicy{ConsecutiveFailures: 1, OpenTimeout: 20 * time.Millisecond, HalfOpenProbes: 2}, logging.Or(nil))
+	do(b, ErrorTimeout)
+	time.Sleep(30 * time.Millisecond)
+
+	// a failed probe opens the breaker again
+	if !do(b, ErrorTimeout) {
+		t.Fatal("the breaker lets a probe through after OpenTimeout")
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("state %s after a failed probe, want open", b.State())
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	first, ok1 := b.allow()
+	second, ok2 := b.allow()
+	if !ok1 || !ok2 {
+		t.Fatal("the breaker lets HalfOpenProbes probes through")
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatal("the breaker lets more than HalfOpenProbes probes through")
+	}
+	// a canceled probe gives its slot back without a verdict
+	b.record(first, ErrorCanceled)
+	third, ok := b.allow()
+	if b.State() != BreakerHalfOpen || !ok {
+		t.Fatal("a canceled probe is not given back")
+	}
+	b.record(second, nil)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("state %s after 1 of 2 probes succeeded, want half-open", b.State())
+	}
+	b.record(third, nil)
+	if b.State() != BreakerClosed {
+		t.Fatalf("state %s after every probe succeeded, want closed", b.State())
+	}
+}
+
+func TestBreakerIgnoresEarlierGenerations(t *testing.T) {
+	b := newBreaker("test", BreakerPolicy{ConsecutiveFailures: 1, OpenTimeout: 20 * time.Millisecond}, logging.Or(nil))
+	slow, _ := b.allow()
+	do(b, ErrorTimeout)
+	time.Sleep(30 * time.Millisecond)
+	probe, ok := b.allow()
+	if !ok || b.State() != BreakerHalfOpen {
+		t.Fatalf("state %s, want a probe through a half-open breaker", b.State())
+	}
+	// the request allowed before the breaker opened is no probe
+	b.record(slow, nil)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("state %s after a stale success, want half-open", b.State())
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatal("a stale result gave a probe slot back")
+	}
+	b.record(probe, nil)
+	if b.State() != BreakerClosed {
+		t.Fatalf("state %s after the probe succeeded, want closed", b.State())
+	}
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"reflect"
+	"sync"
+)
+
+type InstanceResult struct {
+	Instance registry.Instance
+	Reply    interface{}
+	Error    error
+}
+
+func (c *DiscoveryClient) snapshot() ([]registry.Instance, []*instanceStats, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.shutdown {
+		return nil, nil, ErrorShutdown
+	}
+	if len(c.instances) == 0 {
+		return nil, nil, ErrorNoInstance
+	}
+	instances := append([]registry.Instance(nil), c.instances...)
+	stats := make([]*instanceStats, len(instances))
+	for i, instance := range instances {
+		stats[i] = c.stats[instance.Key()]
+	}
+	return instances, stats, nil
+}
+
+// Broadcast calls every instance and returns the result of each, reply only
+// gives the type of the replies. The error is the first failure, if any.
+func (c *DiscoveryClient) Broadcast(ctx context.Context, serviceName string, arg interface{}, reply interface{}) ([]InstanceResult, error) {
+	instances, stats, err := c.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]InstanceResult, len(instances))
+	var wg sync.WaitGroup
+	for i := range instances {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+			err := c.callInstance(ctx, instances[i], stats[i], serviceName, arg, r)
+			results[i] = InstanceResult{Instance: instances[i], Reply: r, Error: err}
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range results {
+		if result.Error != nil {
+			return results, result.Error
+		}
+	}
+	return results, nil
+}
+
+// Fork calls every instance and takes the first successful reply, the other calls are cancelled.
+func (c *DiscoveryClient) Fork(ctx context.Context, serviceName string, arg interface{}, reply interface{}) error {
+	instances, stats, err := c.snapshot()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan InstanceResult, len(instances))
+	for i := range instances {
+		go func(i int) {
+			r := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+			err := c.callInstance(ctx, instances[i], stats[i], serviceName, arg, r)
+			results <- InstanceResult{Instance: instances[i], Reply: r, Error: err}
+		}(i)
+	}
+	for range instances {
+		result := <-results
+		if result.Error == nil {
+			reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(result.Reply).Elem())
+			return nil
+		}
+		err = result.Error
+	}
+	return err
+}
@@ -7,14 +7,42 @@ import (
 	"sync"
 	"github.com/huangw1/rpc-demo/step-3/transport"
 	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"github.com/huangw1/rpc-demo/step-3/logging"
 	"strings"
-	"log"
 	"sync/atomic"
 	"time"
+	"io"
+	"net/http"
+	"bufio"
+	"net"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
-var ErrorShutdown = errors.New("rpc-client: client is shut down")
-var ErrorTimeout = errors.New("rpc-client: request timeout")
+var ErrorShutdown = rpcerr.New(rpcerr.Canceled, "rpc-client: client is shut down")
+var ErrorTimeout = rpcerr.New(rpcerr.DeadlineExceeded, "rpc-client: request timeout")
+var ErrorCanceled = rpcerr.New(rpcerr.Canceled, "rpc-client: request canceled")
+var ErrorConnection = rpcerr.New(rpcerr.Unavailable, "rpc-client: connection is broken")
+var ErrorUnavailable = rpcerr.New(rpcerr.Unavailable, "rpc-client: no connection available")
+
+// DialError is a failed dial on behalf of a call, nothing of the call was sent.
+type DialError struct {
+	Addr string
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return "rpc-client: dial " + e.Addr + ": " + e.Err.Error()
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DialError) RPCError() *rpcerr.Error {
+	return rpcerr.New(rpcerr.Unavailable, e.Error())
+}
 
 type RPCClient interface {
 	Go(ctx context.Context, serviceName string, arg interface{}, reply interface{}, done chan *Call) *Call
@@ -28,6 +56,11 @@ type Call struct {
 	Reply         interface{}
 	Error         error
 	Done          chan *Call
+
+	seq   uint64
+	rwc   transport.Transport // guarded by simpleClient.mutex
+	start time.Time
+	span  trace.Span
 }
 
 func (c *Call) done() {
@@ -39,25 +72,132 @@ type simpleClient struct {
 	rwc          transport.Transport
 	pendingCalls sync.Map
 	mutex        sync.Mutex
-	shutdown     bool
+	state        ConnState
 	option       Option
 	seq          uint64
+	idleTimer    *time.Timer
+	addr         string
+	redial       func(ctx context.Context) (transport.Transport, error)
+	done         chan struct{}
+	pending      int64
+	metrics      *clientMetrics
+	tracing      *clientTracing
+	logger       logging.Logger
+	rpcz         *clientRpcz
+	connectedAt  time.Time
+	dialing      chan struct{}
 }
 
 func NewSimpleClient(network, addr string, option Option) (RPCClient, error) {
-	c := new(simpleClient)
-	c.codec = codec.GetCodec(option.SerializeType)
-	t := transport.NewTransport(option.TransportType)
-	err := t.Dial(network, addr)
+	return NewClient(context.Background(), network, addr, option)
+}
+
+// NewClient dials addr until ctx is done or DialTimeout expires.
+func NewClient(ctx context.Context, network, addr string, option Option) (RPCClient, error) {
+	c, err := newClient(ctx, network, addr, option)
 	if err != nil {
 		return nil, err
 	}
-	c.rwc = t
-	go c.input()
 	return c, nil
 }
 
+func newClient(ctx context.Context, network, addr string, option Option) (*simpleClient, error) {
+	redial := func(ctx context.Context) (transport.Transport, error) {
+		return dial(ctx, network, addr, option)
+	}
+	t, err := redial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newSimpleClient(addr, t, redial, option), nil
+}
+
+func dial(ctx context.Context, network, addr string, option Option) (transport.Transport, error) {
+	if option.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, option.DialTimeout)
+		defer cancel()
+	}
+	t := transport.NewTransport(option.TransportType)
+	err := t.DialContext(ctx, network, addr, option.Dialer)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func DialHTTP(network, addr string, option Option) (RPCClient, error) {
+	return DialHTTPPath(network, addr, protocol.DefaultRPCPath, option)
+}
+
+// DialHTTPPath connects to a server mounted on an http path
+// and switches the connection to the rpc protocol with CONNECT.
+func DialHTTPPath(network, addr, path string, option Option) (RPCClient, error) {
+	redial := func(ctx context.Context) (transport.Transport, error) {
+		return dialHTTP(ctx, network, addr, path, option)
+	}
+	t, err := redial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return newSimpleClient(addr, t, redial, option), nil
+}
+
+func dialHTTP(ctx context.Context, network, addr, path string, option Option) (transport.Transport, error) {
+	t, err := dial(ctx, network, addr, option)
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(t, "CONNECT "+path+" HTTP/1.0\n\n")
+	res, err := http.ReadResponse(bufio.NewReader(t), &http.Request{Method: "CONNECT"})
+	if err == nil && res.Status == protocol.HTTPConnected {
+		return t, nil
+	}
+	if err == nil {
+		err = errors.New("unexpected HTTP response: " + res.Status)
+	}
+	t.Close()
+	return nil, &net.OpError{
+		Op:   "dial-http",
+		Net:  network + " " + addr,
+		Addr: nil,
+		Err:  err,
+	}
+}
+
+func newSimpleClient(addr string, t transport.Transport, redial func(ctx context.Context) (transport.Transport, error), option Option) *simpleClient {
+	c := new(simpleClient)
+	c.option = option
+	c.codec = codec.GetCodec(option.SerializeType)
+	c.addr = addr
+	c.redial = redial
+	c.done = make(chan struct{})
+	c.metrics = newClientMetrics(option.Metrics)
+	c.tracing = newClientTracing(option)
+	c.logger = logging.Or(option.Logger)
+	c.connected(t)
+	c.rpcz = newClientRpcz(option.Rpcz, c)
+	if option.IdleTimeout > 0 {
+		c.idleTimer = time.AfterFunc(option.IdleTimeout, c.closeIdle)
+	}
+	return c
+}
+
 func (c *simpleClient) Go(ctx context.Context, serviceName string, arg interface{}, reply interface{}, done chan *Call) *Call {
+	call := newCall(serviceName, arg, reply, done)
+	// invoke adds the credentials, which may block on a token refresh
+	if len(c.option.Interceptors) == 0 && c.option.Credentials == nil {
+		c.send(ctx, call)
+		return call
+	}
+	go func() {
+		c.intercept(ctx, call)
+		call.done()
+	}()
+	return call
+}
+
+func newCall(serviceName string, arg interface{}, reply interface{}, done chan *Call) *Call {
 	call := new(Call)
 	call.ServiceMethod = serviceName
 	call.Args = arg
@@ -68,13 +208,14 @@ func (c *simpleClient) Go(ctx context.Context, serviceName string, arg interface
 		panic("rpc-client: done channel is unbuffered")
 	}
 	call.Done = done
-	c.send(ctx, call)
 	return call
 }
 
 func (c *simpleClient) send(ctx context.Context, call *Call) {
-	seq := ctx.Value(protocol.RequestSeqKey).(uint64)
-	c.pendingCalls.Store(seq, call)
+	seq, ok := ctx.Value(protocol.RequestSeqKey).(uint64)
+	if !ok {
+		seq = atomic.AddUint64(&c.seq, 1)
+	}
 	serviceMethod := strings.SplitN(call.ServiceMethod, ".", 2)
 	req := protocol.NewMessage(c.option.ProtocolType)
 	req.ServiceName = serviceMethod[0]
@@ -86,96 +227,257 @@ func (c *simpleClient) send(ctx context.Context, call *Call) {
 	if ctx.Value(protocol.MetaDataKey) != nil {
 		req.MetaData = ctx.Value(protocol.MetaDataKey).(map[string]string)
 	}
+	c.tracing.start(ctx, call, req, c.addr)
+	call.seq = seq
+	call.start = time.Now()
+	c.pendingCalls.Store(seq, call)
+	atomic.AddInt64(&c.pending, 1)
+	c.metrics.begin(call)
 	requestData, err := c.codec.Encode(call.Args)
 	if err != nil {
-		log.Println(err)
-		c.pendingCalls.Delete(seq)
-		call.Error = err
-		call.done()
+		c.finish(seq, err)
 		return
 	}
 	req.Data = requestData
+	c.metrics.sent(call, len(requestData))
+	if c.option.SigningKey != nil {
+		if err := sign(req, c.option.SigningKey); err != nil {
+			c.finish(seq, err)
+			return
+		}
+	}
 	data := protocol.EncodeMessage(c.option.ProtocolType, req)
-	_, err = c.rwc.Write(data)
+	rwc, err := c.conn(ctx, call)
 	if err != nil {
-		log.Println(err)
-		c.pendingCalls.Delete(seq)
-		call.Error = err
-		call.done()
+		c.finish(seq, err)
+		return
+	}
+	c.touch()
+	if c.option.WriteTimeout > 0 {
+		rwc.SetWriteDeadline(time.Now().Add(c.option.WriteTimeout))
+	}
+	_, err = rwc.Write(data)
+	if err != nil {
+		c.logger.Warn("rpc-client: fail to write request", logging.KeyAddr, c.addr, logging.KeySeq, seq,
+			logging.KeyService, req.ServiceName, logging.KeyMethod, req.MethodName, logging.KeyError, err)
+		c.finish(seq, err)
 		return
 	}
 }
 
+// finish completes a pending call unless the response, a timeout
+// or a connection failure has already completed it.
+func (c *simpleClient) finish(seq uint64, err error) bool {
+	call := c.takePendingCall(seq)
+	if call == nil {
+		return false
+	}
+	call.Error = err
+	c.complete(call)
+	return true
+}
+
+func (c *simpleClient) complete(call *Call) {
+	c.metrics.end(call)
+	c.tracing.end(call)
+	c.accessLog(call)
+	c.rpcz.end(call)
+	call.done()
+}
+
+// accessLog logs a completed call as Option.AccessLog samples it.
+func (c *simpleClient) accessLog(call *Call) {
+	duration := time.Since(call.start)
+	code := rpcerr.Code(call.Error)
+	if !c.option.AccessLog.Sample(code != rpcerr.OK, duration) {
+		return
+	}
+	args := []interface{}{
+		logging.KeyAddr, c.addr,
+		logging.KeySeq, call.seq,
+		logging.KeyService, call.ServiceMethod,
+		logging.KeyCode, code.String(),
+		logging.KeyDuration, duration,
+	}
+	if call.Error != nil {
+		args = append(args, logging.KeyError, call.Error)
+	}
+	c.logger.Info("rpc-client: access", args...)
+}
+
+func (c *simpleClient) takePendingCall(seq uint64) *Call {
+	pendingCall, ok := c.pendingCalls.LoadAndDelete(seq)
+	if !ok {
+		return nil
+	}
+	atomic.AddInt64(&c.pending, -1)
+	return pendingCall.(*Call)
+}
+
+func (c *simpleClient) failPendingCalls(err error) {
+	c.pendingCalls.Range(func(key, value interface{}) bool {
+		c.finish(key.(uint64), err)
+		return true
+	})
+}
+
+func (c *simpleClient) hasPendingCalls() bool {
+	return atomic.LoadInt64(&c.pending) > 0
+}
+
 func (c *simpleClient) Call(ctx context.Context, serviceName string, arg interface{}, reply interface{}) error {
-	seq := atomic.AddUint64(&c.seq, 1)
-	ctx = context.WithValue(ctx, protocol.RequestSeqKey, seq)
-	cancelFunc := func() {}
 	if c.option.RequestTimeout != time.Duration(0) {
+		var cancelFunc context.CancelFunc
 		ctx, cancelFunc = context.WithTimeout(ctx, c.option.RequestTimeout)
-		metaData := ctx.Value(protocol.MetaDataKey)
-		var meta map[string]string
-		if metaData == nil {
-			meta = make(map[string]string)
-		} else {
-			meta = metaData.(map[string]string)
+		defer cancelFunc()
+		ctx = withMetaData(ctx, protocol.RequestTimeoutKey, c.option.RequestTimeout.String())
+	}
+	call := newCall(serviceName, arg, reply, make(chan *Call, 1))
+	return c.intercept(ctx, call)
+}
+
+// invoke is the end of the interceptor chain, every invocation is sent with a seq of its own.
+func (c *simpleClient) invoke(ctx context.Context, call *Call) error {
+	if c.option.Credentials != nil {
+		token, err := c.option.Credentials.Token(ctx)
+		if err != nil {
+			call.Error = err
+			return err
 		}
-		meta[protocol.MetaDataKey] = c.option.RequestTimeout.String()
-		ctx = context.WithValue(ctx, protocol.MetaDataKey, meta)
+		ctx = withMetaData(ctx, protocol.AuthorizationKey, protocol.BearerPrefix+token)
 	}
-	done := make(chan *Call, 1)
-	call := c.Go(ctx, serviceName, arg, reply, done)
+	seq := atomic.AddUint64(&c.seq, 1)
+	ctx = context.WithValue(ctx, protocol.RequestSeqKey, seq)
+	sent := newCall(call.ServiceMethod, call.Args, call.Reply, make(chan *Call, 1))
+	c.send(ctx, sent)
 	select {
 	case <-ctx.Done():
-		cancelFunc()
-		c.pendingCalls.Delete(seq)
-		call.Error = ErrorTimeout
-		call.done()
-	case <-call.Done:
+		err := ErrorTimeout
+		if errors.Is(ctx.Err(), context.Canceled) {
+			err = ErrorCanceled
+		}
+		if c.finish(seq, err) {
+			go c.sendCancel(sent)
+		}
+		<-sent.Done
+	case <-sent.Done:
 
 	}
-	return call.Error
+	if rpcerr.Code(sent.Error) == rpcerr.Unauthenticated {
+		if invalidator, ok := c.option.Credentials.(interface{ Invalidate() }); ok {
+			invalidator.Invalidate()
+		}
+	}
+	call.Error = sent.Error
+	return sent.Error
+}
+
+// sendCancel lets the server stop working on a call the client has given up on. The cancel
+// goes on the connection that carried the call, and nowhere once that connection is gone.
+func (c *simpleClient) sendCancel(call *Call) {
+	c.mutex.Lock()
+	rwc := call.rwc
+	if rwc == nil || c.state != StateConnected || c.rwc != rwc {
+		c.mutex.Unlock()
+		return
+	}
+	c.mutex.Unlock()
+	req := protocol.NewMessage(c.option.ProtocolType)
+	req.MessageType = protocol.MessageTypeCancel
+	req.Seq = call.seq
+	if c.option.WriteTimeout > 0 {
+		rwc.SetWriteDeadline(time.Now().Add(c.option.WriteTimeout))
+	}
+	rwc.Write(protocol.EncodeMessage(c.option.ProtocolType, req))
 }
 
 func (c *simpleClient) Close() error {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	c.shutdown = true
-	err := c.rwc.Close()
-	c.pendingCalls.Range(func(key, value interface{}) bool {
-		call, ok := key.(*Call)
-		if ok {
-			call.Error = ErrorShutdown
-			call.done()
-		}
-		c.pendingCalls.Delete(key)
-		return true
-	})
+	if c.state == StateShutdown {
+		c.mutex.Unlock()
+		return nil
+	}
+	connected := c.state == StateConnected
+	c.setState(StateShutdown)
+	close(c.done)
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	var err error
+	if connected {
+		err = c.rwc.Close()
+	}
+	c.mutex.Unlock()
+	c.failPendingCalls(ErrorShutdown)
+	c.rpcz.close()
 	return err
 }
 
-func (c *simpleClient) input() {
+func (c *simpleClient) input(rwc transport.Transport) {
 	var err error
 	var res *protocol.Message
+	r := bufio.NewReader(rwc)
 	for err == nil {
-		res, err = protocol.DecodeMessage(c.option.ProtocolType, c.rwc)
+		res, err = c.readResponse(rwc, r)
 		if err != nil {
 			break
 		}
-		seq := res.Seq
-		pendingCall, _ := c.pendingCalls.Load(seq)
-		call := pendingCall.(*Call)
-		c.pendingCalls.Delete(seq)
+		c.touch()
+		call := c.takePendingCall(res.Seq)
 		if call == nil {
-			// nothing to to
-		} else if res.Error != "" {
-			call.Error = errors.New(res.Error)
-			call.done()
-		} else {
-			err = c.codec.Decode(res.Data, call.Reply)
-			if err != nil {
-				call.Error = errors.New("rpc-client: reading body " + err.Error())
-			}
-			call.done()
+			// timed out or failed already, nothing to do
+			continue
 		}
+		c.metrics.received(call, len(res.Data))
+		if res.StatusCode != rpcerr.OK {
+			call.Error = responseError(res)
+		} else if decodeErr := c.codec.Decode(res.Data, call.Reply); decodeErr != nil {
+			call.Error = rpcerr.New(rpcerr.Internal, "rpc-client: reading body "+decodeErr.Error())
+		}
+		c.complete(call)
+	}
+	c.connectionLost(rwc, err)
+}
+
+func responseError(res *protocol.Message) error {
+	return &rpcerr.Error{Code: res.StatusCode, Message: res.Error, Details: res.ErrorDetails}
+}
+
+// readResponse waits for the next response without a deadline
+// and then at most ReadTimeout for the rest of it.
+func (c *simpleClient) readResponse(rwc transport.Transport, r *bufio.Reader) (*protocol.Message, error) {
+	if c.option.ReadTimeout > 0 {
+		_, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		rwc.SetReadDeadline(time.Now().Add(c.option.ReadTimeout))
+		defer rwc.SetReadDeadline(time.Time{})
+	}
+	res, err := protocol.DecodeMessage(c.option.ProtocolType, r)
+	if err != nil && transport.IsTimeout(err) {
+		c.logger.Warn("rpc-client: closing connection, response not read in time", logging.KeyAddr, c.addr, "read_timeout", c.option.ReadTimeout)
+	}
+	return res, err
+}
+
+func (c *simpleClient) touch() {
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.option.IdleTimeout)
+	}
+}
+
+func (c *simpleClient) closeIdle() {
+	if c.hasPendingCalls() {
+		c.touch()
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.state != StateConnected {
+		return
 	}
+	c.logger.Info("rpc-client: closing idle connection", logging.KeyAddr, c.addr, "idle_timeout", c.option.IdleTimeout)
+	c.setState(StateDisconnected)
+	c.rwc.Close()
 }
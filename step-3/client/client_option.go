@@ -1,10 +1,16 @@
 package client
 
 import (
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"github.com/huangw1/rpc-demo/step-3/metrics"
 	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcz"
 	"github.com/huangw1/rpc-demo/step-3/codec"
 	"github.com/huangw1/rpc-demo/step-3/transport"
 	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Option struct {
@@ -13,7 +19,68 @@ type Option struct {
 	CompressType protocol.CompressType
 	TransportType transport.TransportType
 
+	// Interceptors wrap every Call and Go on a connection, the first one is outermost.
+	// Behind a DiscoveryClient they wrap every attempt, retries and hedges included.
+	Interceptors []Interceptor
+
+	// Credentials add a bearer token to the metadata of every request
+	Credentials Credentials
+
+	// SigningKey signs every request with hmac, for servers with SigningKeys
+	SigningKey *SigningKey
+
+	// Metrics records calls, latencies, payload sizes, pending calls and connections
+	Metrics *metrics.Registry
+	// TracerProvider, otel.GetTracerProvider() by default, starts a span for every call and Propagator,
+	// w3c traceparent and tracestate by default, carries its context in the request metadata
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
+
+	// Logger is slog.Default() when nil, AccessLog logs every call through it as it samples them
+	Logger    logging.Logger
+	AccessLog logging.AccessLog
+
+	// Rpcz lists the connection and pending calls of every client, and recent slow
+	// or failed calls, on its debug page, e.g. rpcz.DefaultPage
+	Rpcz *rpcz.Page
+
 	RequestTimeout time.Duration
+	DialTimeout    time.Duration
+	// Dialer replaces the default net.Dialer, e.g. for socks proxies or fault injection
+	Dialer transport.DialFunc
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// KeepAlivePeriod zero keeps the system default, negative disables tcp keepalive
+	KeepAlivePeriod time.Duration
+
+	// ReconnectInterval is the first backoff after the connection is lost, zero redials on the next call instead
+	ReconnectInterval    time.Duration
+	MaxReconnectInterval time.Duration
+	// OnStateChange is called synchronously on every state change and must not call back into the client
+	OnStateChange func(addr string, state ConnState)
+
+	// PoolSize is the number of connections a PooledClient keeps to its address
+	PoolSize     int
+	PoolStrategy PoolStrategy
+
+	// SelectorType is the load balancing of a DiscoveryClient, HashKey feeds ConsistentHashSelect
+	SelectorType SelectorType
+	HashKey      HashKeyFunc
+
+	// RetryPolicy applies to every method of a DiscoveryClient without an entry in MethodRetryPolicies ("Service.Method")
+	RetryPolicy         RetryPolicy
+	MethodRetryPolicies map[string]RetryPolicy
+
+	// HedgePolicy is off by default, hedge latency critical reads with MethodHedgePolicies
+	HedgePolicy         HedgePolicy
+	MethodHedgePolicies map[string]HedgePolicy
+
+	// Breaker is the circuit breaker of every instance of a DiscoveryClient
+	Breaker BreakerPolicy
+	// Throttle drops requests to instances that keep rejecting them as overloaded
+	Throttle ThrottlePolicy
 }
 
 var DefaultOption = Option{
@@ -22,4 +89,28 @@ var DefaultOption = Option{
 	CompressType: protocol.CompressTypeNone,
 	TransportType: transport.TCPTransport,
 	RequestTimeout: time.Second * 60,
+	DialTimeout: time.Second * 10,
+	ReconnectInterval: time.Millisecond * 100,
+	MaxReconnectInterval: time.Second * 30,
+	PoolSize: 4,
+	PoolStrategy: LeastPending,
+	SelectorType: PowerOfTwoSelect,
+	RetryPolicy: RetryPolicy{
+		FailMode:    FailOver,
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond * 10,
+		MaxBackoff:  time.Second,
+	},
+	Breaker: BreakerPolicy{
+		ConsecutiveFailures: 5,
+		FailureRatio:        0.5,
+		MinRequests:         20,
+		Window:              time.Second * 10,
+		OpenTimeout:         time.Second * 5,
+		HalfOpenProbes:      1,
+	},
+	Throttle: ThrottlePolicy{
+		K:      2,
+		Window: time.Minute * 2,
+	},
 }
\ No newline at end of file
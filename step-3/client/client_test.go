@@ -0,0 +1,57 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+type Args struct{ A, B int }
+
+type Reply struct{ C int }
+
+// Arith adds after Delay.
+type Arith struct {
+	Delay time.Duration
+}
+
+func (a Arith) Add(ctx context.Context, args Args, reply *Reply) error {
+	select {
+	case <-time.After(a.Delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	reply.C = args.A + args.B
+	return nil
+}
+
+func newDiscoveryClient(t *testing.T, option client.Option, addrs ...string) *client.DiscoveryClient {
+	t.Helper()
+	var instances []registry.Instance
+	for _, addr := range addrs {
+		instances = append(instances, registry.Instance{Service: "Arith", Network: "tcp", Addr: addr})
+	}
+	c, err := client.NewDiscoveryClient(registry.NewStaticDiscovery(instances...), option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCall(t *testing.T) {
+	addr := client.Serve(t, Arith{}, server.DefaultOption)
+	c, err := client.NewSimpleClient("tcp", addr, client.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reply := &Reply{}
+	if err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, reply); err != nil || reply.C != 3 {
+		t.Fatal(err, reply.C)
+	}
+}
@@ -0,0 +1,131 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/server"
+	"github.com/huangw1/rpc-demo/step-3/transport"
+)
+
+// stall answers the first request on a connection with one byte of a response and nothing more.
+func stall(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := conn.Read(make([]byte, 1024)); err != nil {
+					return
+				}
+				conn.Write([]byte{0})
+				io.Copy(ioutil.Discard, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestReadTimeout(t *testing.T) {
+	option := client.DefaultOption
+	option.ReadTimeout = 30 * time.Millisecond
+	// the deadline starts with the response, a slow method is not a timeout
+	c, err := client.NewSimpleClient("tcp", client.Serve(t, Arith{Delay: 100 * time.Millisecond}, server.DefaultOption), option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var reply Reply
+	if err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &reply); err != nil || reply.C != 3 {
+		t.Fatal(err, reply)
+	}
+
+	c, err = client.NewSimpleClient("tcp", stall(t), option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+	if err := c.Call(context.Background(), "Arith.Add", Args{}, &Reply{}); err != client.ErrorConnection {
+		t.Fatalf("got %v from a stalled response, want %v", err, client.ErrorConnection)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("stalled response failed after %s", elapsed)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	// nobody reads the other end of the pipe
+	var mutex sync.Mutex
+	var peers []net.Conn
+	option := client.DefaultOption
+	option.WriteTimeout = 30 * time.Millisecond
+	option.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, peer := net.Pipe()
+		mutex.Lock()
+		peers = append(peers, peer)
+		mutex.Unlock()
+		return conn, nil
+	}
+	defer func() {
+		for _, peer := range peers {
+			peer.Close()
+		}
+	}()
+	c, err := client.NewSimpleClient("tcp", "10.0.0.1:7000", option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+	if err := c.Call(context.Background(), "Arith.Add", Args{}, &Reply{}); !transport.IsTimeout(err) {
+		t.Fatalf("got %v from a blocked write, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("blocked write failed after %s", elapsed)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	states := make(chan client.ConnState, 10)
+	option := client.DefaultOption
+	option.IdleTimeout = 50 * time.Millisecond
+	option.OnStateChange = func(addr string, state client.ConnState) { states <- state }
+	c, err := client.NewSimpleClient("tcp", client.Serve(t, Arith{Delay: 100 * time.Millisecond}, server.DefaultOption), option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	<-states
+	// a pending call keeps the connection open past the idle timeout
+	if err := c.Call(context.Background(), "Arith.Add", Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case state := <-states:
+		if state != client.StateDisconnected {
+			t.Fatalf("state %s, want %s", state, client.StateDisconnected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle connection not closed")
+	}
+	// the next call redials
+	if err := c.Call(context.Background(), "Arith.Add", Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,68 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+// hang dials nothing and waits for ctx.
+func hang(ctx context.Context, network, addr string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestNewClientHonorsContext(t *testing.T) {
+	option := client.DefaultOption
+	option.Dialer = hang
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := client.NewClient(ctx, "tcp", "10.0.0.1:7000", option); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("dial returned %s after the cancel", elapsed)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	option := client.DefaultOption
+	option.Dialer = hang
+	option.DialTimeout = 20 * time.Millisecond
+	start := time.Now()
+	if _, err := client.NewClient(context.Background(), "tcp", "10.0.0.1:7000", option); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("dial returned after %s", elapsed)
+	}
+}
+
+func TestDialer(t *testing.T) {
+	addr := client.Serve(t, Arith{}, server.DefaultOption)
+	var dialed []string
+	option := client.DefaultOption
+	option.Dialer = func(ctx context.Context, network, target string) (net.Conn, error) {
+		dialed = append(dialed, target)
+		// every address resolves to the test server
+		return new(net.Dialer).DialContext(ctx, network, addr)
+	}
+	c, err := client.NewClient(context.Background(), "tcp", "arith.internal:7000", option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var reply Reply
+	if err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &reply); err != nil || reply.C != 3 {
+		t.Fatal(err, reply)
+	}
+	if len(dialed) != 1 || dialed[0] != "arith.internal:7000" {
+		t.Fatalf("dialed %v", dialed)
+	}
+}
@@ -0,0 +1,304 @@
+package client
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrorNoInstance = rpcerr.New(rpcerr.Unavailable, "rpc-client: no available instance")
+
+// DiscoveryClient follows the instances of a registry.Discovery, picks one
+// per call with its Selector and keeps a PooledClient per instance, dialed on first use.
+type DiscoveryClient struct {
+	discovery registry.Discovery
+	watcher   registry.Watcher
+	option    Option
+	selector  Selector
+	mutex     sync.Mutex
+	instances []registry.Instance
+	clients   map[string]*PooledClient
+	stats     map[string]*instanceStats
+	shutdown  bool
+
+	latencies   sync.Map
+	hedgeBudget hedgeBudget
+	hedged      uint64
+}
+
+type InstanceStats struct {
+	Instance    registry.Instance
+	Weight      int
+	Outstanding int64
+	Requests    uint64
+	Errors      uint64
+	Breaker     BreakerState
+	// DropRate is the adaptive throttling probability, Dropped the requests dropped so far
+	DropRate float64
+	Dropped  uint64
+	Pool     *PoolStats
+}
+
+type instanceStats struct {
+	outstanding int64
+	requests    uint64
+	errors      uint64
+	breaker     *breaker
+	throttle    *throttle
+}
+
+func (s *instanceStats) begin() {
+	atomic.AddInt64(&s.outstanding, 1)
+	atomic.AddUint64(&s.requests, 1)
+}
+
+func (s *instanceStats) end(err error) {
+	atomic.AddInt64(&s.outstanding, -1)
+	if err != nil && !Canceled(err) {
+		atomic.AddUint64(&s.errors, 1)
+	}
+}
+
+func NewDiscoveryClient(discovery registry.Discovery, option Option) (*DiscoveryClient, error) {
+	// watch before the snapshot, so no change between the two is lost
+	watcher := discovery.Watch()
+	instances, err := discovery.Instances()
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	c := new(DiscoveryClient)
+	c.discovery = discovery
+	c.option = option
+	c.clients = make(map[string]*PooledClient)
+	c.stats = make(map[string]*instanceStats)
+	c.selector = NewSelector(option.SelectorType, c.load, option.HashKey)
+	c.update(instances)
+	c.watcher = watcher
+	go c.watch()
+	return c, nil
+}
+
+func (c *DiscoveryClient) watch() {
+	for {
+		instances, err := c.watcher.Next()
+		if err != nil {
+			return
+		}
+		c.update(instances)
+	}
+}
+
+func (c *DiscoveryClient) update(instances []registry.Instance) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.shutdown {
+		return
+	}
+	c.instances = instances
+	alive := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		alive[instance.Key()] = true
+		if _, ok := c.stats[instance.Key()]; !ok {
+			c.stats[instance.Key()] = &instanceStats{
+				breaker:  newBreaker(instance.Addr, c.option.Breaker, logging.Or(c.option.Logger)),
+				throttle: newThrottle(c.option.Throttle),
+			}
+		}
+	}
+	for key := range c.stats {
+		if !alive[key] {
+			delete(c.stats, key)
+		}
+	}
+	for key, pool := range c.clients {
+		if !alive[key] {
+			logging.Or(c.option.Logger).Info("rpc-client: instance is gone", "instance", key)
+			delete(c.clients, key)
+			go pool.Close()
+		}
+	}
+	c.selector.Update(instances)
+}
+
+// load must be called with c.mutex held, as selectors are.
+func (c *DiscoveryClient) load(instance registry.Instance) int64 {
+	if stats, ok := c.stats[instance.Key()]; ok {
+		return atomic.LoadInt64(&stats.outstanding)
+	}
+	return 0
+}
+
+func (c *DiscoveryClient) SelectorName() string {
+	return c.selector.Name()
+}
+
+// Hedged is the number of backup requests sent so far.
+func (c *DiscoveryClient) Hedged() uint64 {
+	return atomic.LoadUint64(&c.hedged)
+}
+
+func (c *DiscoveryClient) InstanceStats() []InstanceStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := make([]InstanceStats, 0, len(c.instances))
+	for _, instance := range c.instances {
+		s := c.stats[instance.Key()]
+		is := InstanceStats{
+			Instance:    instance,
+			Weight:      instance.Weight(),
+			Outstanding: atomic.LoadInt64(&s.outstanding),
+			Requests:    atomic.LoadUint64(&s.requests),
+			Errors:      atomic.LoadUint64(&s.errors),
+			Breaker:     s.breaker.State(),
+			DropRate:    s.throttle.DropRate(),
+			Dropped:     s.throttle.Dropped(),
+		}
+		if pool, ok := c.clients[instance.Key()]; ok {
+			poolStats := pool.Stats()
+			is.Pool = &poolStats
+		}
+		stats = append(stats, is)
+	}
+	return stats
+}
+
+func (c *DiscoveryClient) Instances() []registry.Instance {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]registry.Instance(nil), c.instances...)
+}
+
+func (c *DiscoveryClient) PoolStats() []PoolStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := make([]PoolStats, 0, len(c.clients))
+	for _, pool := range c.clients {
+		stats = append(stats, pool.Stats())
+	}
+	return stats
+}
+
+// selectInstance skips instances with an open circuit breaker, and the
+// instances of exclude unless nothing else is left.
+func (c *DiscoveryClient) selectInstance(ctx context.Context, serviceMethod string, arg interface{}, exclude []string) (registry.Instance, *instanceStats, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.shutdown {
+		return registry.Instance{}, nil, ErrorShutdown
+	}
+	if len(c.instances) == 0 {
+		return registry.Instance{}, nil, ErrorNoInstance
+	}
+	ready := make([]registry.Instance, 0, len(c.instances))
+	for _, instance := range c.instances {
+		if c.stats[instance.Key()].breaker.ready() {
+			ready = append(ready, instance)
+		}
+	}
+	if len(ready) == 0 {
+		return registry.Instance{}, nil, ErrorCircuitOpen
+	}
+	instances := ready
+	if len(exclude) > 0 {
+		instances = make([]registry.Instance, 0, len(ready))
+		for _, instance := range ready {
+			if !contains(exclude, instance.Key()) {
+				instances = append(instances, instance)
+			}
+		}
+		if len(instances) == 0 {
+			instances = ready
+		}
+	}
+	instance := c.selector.Select(ctx, serviceMethod, arg, instances)
+	return instance, c.stats[instance.Key()], nil
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *DiscoveryClient) client(ctx context.Context, instance registry.Instance) (*PooledClient, error) {
+	key := instance.Key()
+	c.mutex.Lock()
+	pool, ok := c.clients[key]
+	c.mutex.Unlock()
+	if ok {
+		return pool, nil
+	}
+	pool, err := NewPooledClient(ctx, instance.Network, instance.Addr, c.option)
+	if err != nil {
+		return nil, &DialError{Addr: instance.Addr, Err: err}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if existing, ok := c.clients[key]; ok {
+		go pool.Close()
+		return existing, nil
+	}
+	if c.shutdown {
+		go pool.Close()
+		return nil, ErrorShutdown
+	}
+	c.clients[key] = pool
+	return pool, nil
+}
+
+func (c *DiscoveryClient) Go(ctx context.Context, serviceName string, arg interface{}, reply interface{}, done chan *Call) *Call {
+	call := newCall(serviceName, arg, reply, done)
+	go func() {
+		call.Error = c.Call(ctx, serviceName, arg, reply)
+		call.done()
+	}()
+	return call
+}
+
+func (c *DiscoveryClient) Call(ctx context.Context, serviceName string, arg interface{}, reply interface{}) error {
+	return c.callWithRetry(ctx, serviceName, arg, reply)
+}
+
+func (c *DiscoveryClient) callInstance(ctx context.Context, instance registry.Instance, stats *instanceStats, serviceName string, arg interface{}, reply interface{}) error {
+	if !stats.throttle.allow() {
+		return ErrorThrottled
+	}
+	generation, ok := stats.breaker.allow()
+	if !ok {
+		return ErrorCircuitOpen
+	}
+	stats.begin()
+	pool, err := c.client(ctx, instance)
+	if err == nil {
+		err = pool.Call(ctx, serviceName, arg, reply)
+	}
+	stats.end(err)
+	stats.breaker.record(generation, err)
+	stats.throttle.record(err)
+	return err
+}
+
+func (c *DiscoveryClient) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.shutdown {
+		return nil
+	}
+	c.shutdown = true
+	c.watcher.Close()
+	var err error
+	for key, pool := range c.clients {
+		if closeErr := pool.Close(); closeErr != nil {
+			err = closeErr
+		}
+		delete(c.clients, key)
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func TestForkLoserIsNotAFailure(t *testing.T) {
+	fast := client.Serve(t, Arith{}, server.DefaultOption)
+	slow := client.Serve(t, Arith{Delay: 50 * time.Millisecond}, server.DefaultOption)
+	option := client.DefaultOption
+	option.Breaker.ConsecutiveFailures = 3
+	c := newDiscoveryClient(t, option, fast, slow)
+	for i := 0; i < 6; i++ {
+		if err := c.Fork(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &Reply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, stats := range c.InstanceStats() {
+		if stats.Breaker != client.BreakerClosed || stats.Errors != 0 {
+			t.Fatalf("%s: breaker %s with %d errors", stats.Instance.Addr, stats.Breaker, stats.Errors)
+		}
+	}
+}
+
+func TestDiscoveryClientFollowsUpdates(t *testing.T) {
+	first := client.Serve(t, Arith{}, server.DefaultOption)
+	second := client.Serve(t, Arith{}, server.DefaultOption)
+	d := registry.NewStaticDiscovery(registry.Instance{Service: "Arith", Network: "tcp", Addr: first})
+	c, err := client.NewDiscoveryClient(d, client.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	d.Update(registry.Instance{Service: "Arith", Network: "tcp", Addr: second})
+	for i := 0; ; i++ {
+		instances := c.Instances()
+		if len(instances) == 1 && instances[0].Addr == second {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("instances %v, want %s", instances, second)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+// serve starts a server of rcvr on a free local port and returns its address once it listens.
+func serve(t *testing.T, rcvr interface{}, option server.Option) string {
+	t.Helper()
+	s := server.NewSimpleServer(option)
+	if err := s.Register(rcvr, nil); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- s.Serve("tcp", "127.0.0.1:0") }()
+	t.Cleanup(func() { s.Close() })
+	for s.Addr() == nil {
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return s.Addr().String()
+}
+
+// Serve is serve for the external tests.
+var Serve = serve
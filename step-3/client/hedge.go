@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HedgePolicy sends a backup request to another instance when the first one
+// has not answered within Delay, or within the Percentile of observed latencies
+// once enough have been observed. The first reply wins and the other call is cancelled.
+type HedgePolicy struct {
+	Delay      time.Duration
+	Percentile float64
+	// BudgetRatio caps backup requests to this fraction of hedged calls, 0.1 when zero
+	BudgetRatio float64
+}
+
+func (p HedgePolicy) enabled() bool {
+	return p.Delay > 0 || p.Percentile > 0
+}
+
+func (o Option) hedgePolicy(serviceMethod string) HedgePolicy {
+	if policy, ok := o.MethodHedgePolicies[serviceMethod]; ok {
+		return policy
+	}
+	return o.HedgePolicy
+}
+
+const (
+	latencySamples   = 128
+	minLatencySample = 16
+	maxHedgeTokens   = 10
+)
+
+// latencyWindow keeps the latest latencies of one method.
+type latencyWindow struct {
+	mutex   sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, latency)
+		return
+	}
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % latencySamples
+}
+
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	w.mutex.Lock()
+	samples := append([]time.Duration(nil), w.samples...)
+	w.mutex.Unlock()
+	if len(samples) < minLatencySample {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	i := int(p * float64(len(samples)))
+	if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	return samples[i]
+}
+
+// hedgeBudget earns BudgetRatio tokens per hedged call and spends one per backup request.
+type hedgeBudget struct {
+	mutex  sync.Mutex
+	tokens float64
+}
+
+func (b *hedgeBudget) deposit(ratio float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens += ratio
+	if b.tokens > maxHedgeTokens {
+		b.tokens = maxHedgeTokens
+	}
+}
+
+func (b *hedgeBudget) withdraw() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (c *DiscoveryClient) latency(serviceMethod string) *latencyWindow {
+	window, _ := c.latencies.LoadOrStore(serviceMethod, new(latencyWindow))
+	return window.(*latencyWindow)
+}
+
+func (c *DiscoveryClient) hedgeDelay(serviceMethod string, policy HedgePolicy) time.Duration {
+	if policy.Percentile > 0 {
+		if delay := c.latency(serviceMethod).percentile(policy.Percentile); delay > 0 {
+			return delay
+		}
+	}
+	return policy.Delay
+}
+
+type hedgeResult struct {
+	instance registry.Instance
+	reply    interface{}
+	err      error
+}
+
+// callHedged calls instance and, if the method is hedged, a backup instance.
+// It returns the instance whose reply was taken.
+func (c *DiscoveryClient) callHedged(ctx context.Context, instance registry.Instance, stats *instanceStats, serviceName string, arg interface{}, reply interface{}) (registry.Instance, error) {
+	policy := c.option.hedgePolicy(serviceName)
+	if !policy.enabled() {
+		return instance, c.callInstance(ctx, instance, stats, serviceName, arg, reply)
+	}
+	ratio := policy.BudgetRatio
+	if ratio <= 0 {
+		ratio = 0.1
+	}
+	c.hedgeBudget.deposit(ratio)
+	delay := c.hedgeDelay(serviceName, policy)
+	ctx, cancel := context.WithCancel(ctx)
+	// cancelling the context makes the losing call send a cancel message
+	defer cancel()
+	results := make(chan hedgeResult, 2)
+	launch := func(instance registry.Instance, stats *instanceStats) {
+		// every call decodes into its own reply, the loser may still be decoding
+		r := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+		go func() {
+			start := time.Now()
+			err := c.callInstance(ctx, instance, stats, serviceName, arg, r)
+			if err == nil {
+				c.latency(serviceName).add(time.Since(start))
+			}
+			results <- hedgeResult{instance: instance, reply: r, err: err}
+		}()
+	}
+	launch(instance, stats)
+	pending := 1
+	var timer <-chan time.Time
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+	for {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil || pending == 0 {
+				if res.err == nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(res.reply).Elem())
+				}
+				return res.instance, res.err
+			}
+		case <-timer:
+			timer = nil
+			if !c.hedgeBudget.withdraw() {
+				continue
+			}
+			backup, backupStats, err := c.selectInstance(ctx, serviceName, arg, []string{instance.Key()})
+			if err != nil || backup.Key() == instance.Key() {
+				continue
+			}
+			atomic.AddUint64(&c.hedged, 1)
+			launch(backup, backupStats)
+			pending++
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func TestLatencyPercentile(t *testing.T) {
+	w := new(latencyWindow)
+	for i := 1; i < minLatencySample; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	if d := w.percentile(0.9); d != 0 {
+		t.Fatalf("percentile %s of %d samples, want none", d, minLatencySample-1)
+	}
+	for i := minLatencySample; i <= 100; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	if d := w.percentile(0.9); d != 91*time.Millisecond {
+		t.Fatalf("p90 of 1..100ms is %s, want 91ms", d)
+	}
+	// only the latest latencySamples count
+	for i := 0; i < latencySamples; i++ {
+		w.add(time.Second)
+	}
+	if d := w.percentile(0.1); d != time.Second {
+		t.Fatalf("p10 %s, want the older samples gone", d)
+	}
+}
+
+func TestHedgeBudget(t *testing.T) {
+	b := new(hedgeBudget)
+	for i := 0; i < 9; i++ {
+		b.deposit(0.1)
+	}
+	if b.withdraw() {
+		t.Fatal("a backup request after 9 hedged calls at ratio 0.1")
+	}
+	b.deposit(0.1)
+	b.deposit(0.01)
+	if !b.withdraw() {
+		t.Fatal("no backup request after 10 hedged calls at ratio 0.1")
+	}
+	for i := 0; i < 100; i++ {
+		b.deposit(1)
+	}
+	withdrawn := 0
+	for b.withdraw() {
+		withdrawn++
+	}
+	if withdrawn != maxHedgeTokens {
+		t.Fatalf("%d backup requests saved up, want %d", withdrawn, maxHedgeTokens)
+	}
+}
+
+// Gate holds its first call until the call is canceled, the others return at once.
+type Gate struct {
+	calls    *int32
+	canceled chan struct{}
+}
+
+func (g Gate) Pass(ctx context.Context, arg int, reply *int) error {
+	if atomic.AddInt32(g.calls, 1) == 1 {
+		select {
+		case <-ctx.Done():
+			close(g.canceled)
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	*reply = arg
+	return nil
+}
+
+func TestHedgeCancelsTheLoser(t *testing.T) {
+	gate := Gate{calls: new(int32), canceled: make(chan struct{})}
+	option := DefaultOption
+	option.HedgePolicy = HedgePolicy{Delay: 20 * time.Millisecond, BudgetRatio: 1}
+	d := registry.NewStaticDiscovery(
+		registry.Instance{Service: "Gate", Network: "tcp", Addr: serve(t, gate, server.DefaultOption)},
+		registry.Instance{Service: "Gate", Network: "tcp", Addr: serve(t, gate, server.DefaultOption)},
+	)
+	c, err := NewDiscoveryClient(d, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+	var reply int
+	if err := c.Call(context.Background(), "Gate.Pass", 1, &reply); err != nil || reply != 1 {
+		t.Fatal(err, reply)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("call took %s, want the backup request to answer", elapsed)
+	}
+	if hedged := atomic.LoadUint64(&c.hedged); hedged != 1 {
+		t.Fatalf("%d backup requests, want 1", hedged)
+	}
+	select {
+	case <-gate.canceled:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("the losing call was not canceled on its server")
+	}
+}
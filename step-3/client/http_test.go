@@ -0,0 +1,43 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func TestDialHTTP(t *testing.T) {
+	s := server.NewSimpleServer(server.DefaultOption)
+	if err := s.Register(Arith{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/rpc", s)
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+	addr := hs.Listener.Addr().String()
+
+	c, err := client.DialHTTPPath("tcp", addr, "/rpc", client.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var reply Reply
+	if err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &reply); err != nil || reply.C != 3 {
+		t.Fatal(err, reply)
+	}
+	// the handshake fails on a path that does not answer the CONNECT
+	if c, err := client.DialHTTPPath("tcp", addr, "/missing", client.DefaultOption); err == nil {
+		c.Close()
+		t.Fatal("connected to a path without the server")
+	}
+	// or on the default path, where nothing is mounted
+	if c, err := client.DialHTTP("tcp", addr, client.DefaultOption); err == nil {
+		c.Close()
+		t.Fatal("connected to the default path without the server")
+	}
+}
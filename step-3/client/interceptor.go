@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+)
+
+// Invoker sends call and waits for it, the returned error is also set as call.Error.
+type Invoker func(ctx context.Context, call *Call) error
+
+// Interceptor wraps every Call and Go of a client. It may change the metadata
+// of ctx, call.Args or call.Reply before calling next, call next again to retry,
+// or return without calling it. call.Done is delivered once the chain returns.
+type Interceptor func(ctx context.Context, call *Call, next Invoker) error
+
+// chain runs interceptors in order, the first one is outermost.
+func chain(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, call *Call) error {
+			return interceptor(ctx, call, next)
+		}
+	}
+	return invoker
+}
+
+func (c *simpleClient) intercept(ctx context.Context, call *Call) error {
+	err := chain(c.option.Interceptors, c.invoke)(ctx, call)
+	call.Error = err
+	return err
+}
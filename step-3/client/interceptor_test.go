@@ -0,0 +1,91 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func interceptedClient(t *testing.T, interceptors ...client.Interceptor) client.RPCClient {
+	t.Helper()
+	option := client.DefaultOption
+	option.Interceptors = interceptors
+	c, err := client.NewSimpleClient("tcp", client.Serve(t, Arith{}, server.DefaultOption), option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	var steps []string
+	step := func(name string) client.Interceptor {
+		return func(ctx context.Context, call *client.Call, next client.Invoker) error {
+			steps = append(steps, name+" "+call.ServiceMethod)
+			err := next(ctx, call)
+			steps = append(steps, name+" out")
+			return err
+		}
+	}
+	c := interceptedClient(t, step("first"), step("second"))
+	var reply Reply
+	if err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &reply); err != nil || reply.C != 3 {
+		t.Fatal(err, reply)
+	}
+	want := []string{"first Arith.Add", "second Arith.Add", "second out", "first out"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Fatalf("got %v, want %v", steps, want)
+	}
+}
+
+func TestInterceptorShortCircuits(t *testing.T) {
+	denied := errors.New("denied")
+	inner := false
+	c := interceptedClient(t,
+		func(ctx context.Context, call *client.Call, next client.Invoker) error {
+			return denied
+		},
+		func(ctx context.Context, call *client.Call, next client.Invoker) error {
+			inner = true
+			return next(ctx, call)
+		})
+	reply := Reply{C: 7}
+	call := c.Go(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &reply, nil)
+	select {
+	case <-call.Done:
+	case <-time.After(time.Second):
+		t.Fatal("call not done")
+	}
+	if call.Error != denied || inner || reply.C != 7 {
+		t.Fatalf("error %v, inner ran %t, reply %d, want the call stopped", call.Error, inner, reply.C)
+	}
+}
+
+func TestInterceptorRetries(t *testing.T) {
+	attempts := 0
+	c := interceptedClient(t, func(ctx context.Context, call *client.Call, next client.Invoker) error {
+		var err error
+		for attempts < 3 {
+			attempts++
+			if err = next(ctx, call); err == nil {
+				break
+			}
+			// the next attempt goes to a method that exists
+			call.ServiceMethod = "Arith.Add"
+		}
+		return err
+	})
+	var reply Reply
+	if err := c.Call(context.Background(), "Arith.Missing", Args{A: 1, B: 2}, &reply); err != nil || reply.C != 3 {
+		t.Fatal(err, reply)
+	}
+	if attempts != 2 {
+		t.Fatalf("%d attempts, want 2", attempts)
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"github.com/huangw1/rpc-demo/step-3/metrics"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"strings"
+	"time"
+)
+
+// clientMetrics is nil without Option.Metrics, every method is then a no-op.
+type clientMetrics struct {
+	requests     *metrics.CounterVec
+	latency      *metrics.HistogramVec
+	requestSize  *metrics.HistogramVec
+	responseSize *metrics.HistogramVec
+	pending      *metrics.GaugeVec
+	connections  *metrics.Gauge
+}
+
+func newClientMetrics(registry *metrics.Registry) *clientMetrics {
+	if registry == nil {
+		return nil
+	}
+	return &clientMetrics{
+		requests: registry.NewCounterVec("rpc_client_requests_total",
+			"Calls completed by the client, by status code.", "service", "method", "code"),
+		latency: registry.NewHistogramVec("rpc_client_request_duration_seconds",
+			"Time from sending a call to its completion.", metrics.DefaultLatencyBuckets, "service", "method"),
+		requestSize: registry.NewHistogramVec("rpc_client_request_bytes",
+			"Payload size of requests.", metrics.DefaultSizeBuckets, "service", "method"),
+		responseSize: registry.NewHistogramVec("rpc_client_response_bytes",
+			"Payload size of responses.", metrics.DefaultSizeBuckets, "service", "method"),
+		pending: registry.NewGaugeVec("rpc_client_pending_calls",
+			"Calls waiting for their response.", "service", "method"),
+		connections: registry.NewGaugeVec("rpc_client_connections",
+			"Open connections to servers.").With(),
+	}
+}
+
+func splitServiceMethod(serviceMethod string) (string, string) {
+	parts := strings.SplitN(serviceMethod, ".", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func (m *clientMetrics) connOpened() {
+	if m != nil {
+		m.connections.Inc()
+	}
+}
+
+func (m *clientMetrics) connClosed() {
+	if m != nil {
+		m.connections.Dec()
+	}
+}
+
+func (m *clientMetrics) begin(call *Call) {
+	if m != nil {
+		service, method := splitServiceMethod(call.ServiceMethod)
+		m.pending.With(service, method).Inc()
+	}
+}
+
+func (m *clientMetrics) sent(call *Call, size int) {
+	if m != nil {
+		service, method := splitServiceMethod(call.ServiceMethod)
+		m.requestSize.With(service, method).Observe(float64(size))
+	}
+}
+
+func (m *clientMetrics) received(call *Call, size int) {
+	if m != nil {
+		service, method := splitServiceMethod(call.ServiceMethod)
+		m.responseSize.With(service, method).Observe(float64(size))
+	}
+}
+
+func (m *clientMetrics) end(call *Call) {
+	if m != nil {
+		service, method := splitServiceMethod(call.ServiceMethod)
+		m.pending.With(service, method).Dec()
+		m.requests.With(service, method, rpcerr.Code(call.Error).String()).Inc()
+		m.latency.With(service, method).Observe(time.Since(call.start).Seconds())
+	}
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type PoolStrategy byte
+
+const (
+	RoundRobin PoolStrategy = iota
+	LeastPending
+)
+
+type PoolStats struct {
+	Addr         string
+	Size         int
+	Conns        int
+	Healthy      int
+	Pending      int64
+	Evicted      uint64
+	DialFailures uint64
+}
+
+// PooledClient keeps PoolSize connections to one address and spreads calls across them.
+// Connections that start reconnecting are evicted and replaced in background.
+type PooledClient struct {
+	network      string
+	addr         string
+	size         int
+	option       Option
+	mutex        sync.Mutex
+	clients      []*simpleClient
+	next         uint64
+	evicted      uint64
+	dialFailures uint64
+	refilling    bool
+	shutdown     bool
+	done         chan struct{}
+}
+
+func NewPooledClient(ctx context.Context, network, addr string, option Option) (*PooledClient, error) {
+	p := new(PooledClient)
+	p.network = network
+	p.addr = addr
+	p.option = option
+	p.size = option.PoolSize
+	if p.size <= 0 {
+		p.size = 1
+	}
+	p.done = make(chan struct{})
+	for i := 0; i < p.size; i++ {
+		c, err := newClient(ctx, network, addr, option)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.clients = append(p.clients, c)
+	}
+	return p, nil
+}
+
+func (p *PooledClient) Go(ctx context.Context, serviceName string, arg interface{}, reply interface{}, done chan *Call) *Call {
+	c, err := p.pick()
+	if err != nil {
+		call := newCall(serviceName, arg, reply, done)
+		call.Error = err
+		call.done()
+		return call
+	}
+	return c.Go(ctx, serviceName, arg, reply, done)
+}
+
+func (p *PooledClient) Call(ctx context.Context, serviceName string, arg interface{}, reply interface{}) error {
+	c, err := p.pick()
+	if err != nil {
+		return err
+	}
+	return c.Call(ctx, serviceName, arg, reply)
+}
+
+func (p *PooledClient) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.shutdown {
+		return nil
+	}
+	p.shutdown = true
+	close(p.done)
+	var err error
+	for _, c := range p.clients {
+		if closeErr := c.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	p.clients = nil
+	return err
+}
+
+func (p *PooledClient) Stats() PoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := PoolStats{
+		Addr:         p.addr,
+		Size:         p.size,
+		Conns:        len(p.clients),
+		Evicted:      p.evicted,
+		DialFailures: p.dialFailures,
+	}
+	for _, c := range p.clients {
+		if c.connState() == StateConnected {
+			stats.Healthy++
+		}
+		stats.Pending += atomic.LoadInt64(&c.pending)
+	}
+	return stats
+}
+
+func (p *PooledClient) pick() (*simpleClient, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.shutdown {
+		return nil, ErrorShutdown
+	}
+	p.evict()
+	if len(p.clients) == 0 {
+		return nil, ErrorUnavailable
+	}
+	if p.option.PoolStrategy == LeastPending {
+		picked := p.clients[0]
+		for _, c := range p.clients[1:] {
+			if atomic.LoadInt64(&c.pending) < atomic.LoadInt64(&picked.pending) {
+				picked = c
+			}
+		}
+		return picked, nil
+	}
+	p.next++
+	return p.clients[p.next%uint64(len(p.clients))], nil
+}
+
+// evict must be called with p.mutex held.
+func (p *PooledClient) evict() {
+	healthy := make([]*simpleClient, 0, len(p.clients))
+	for _, c := range p.clients {
+		state := c.connState()
+		if state == StateReconnecting || state == StateShutdown {
+			c.logger.Info("rpc-client: evicting connection from pool", logging.KeyAddr, p.addr, "state", state.String())
+			p.evicted++
+			go c.Close()
+			continue
+		}
+		healthy = append(healthy, c)
+	}
+	p.clients = healthy
+	if len(p.clients) < p.size && !p.refilling {
+		p.refilling = true
+		go p.refill()
+	}
+}
+
+func (p *PooledClient) refill() {
+	interval := p.option.ReconnectInterval
+	if interval <= 0 {
+		interval = time.Millisecond * 100
+	}
+	for {
+		p.mutex.Lock()
+		if p.shutdown || len(p.clients) >= p.size {
+			p.refilling = false
+			p.mutex.Unlock()
+			return
+		}
+		p.mutex.Unlock()
+		c, err := newClient(context.Background(), p.network, p.addr, p.option)
+		p.mutex.Lock()
+		if err == nil {
+			if p.shutdown {
+				p.mutex.Unlock()
+				c.Close()
+				return
+			}
+			p.clients = append(p.clients, c)
+			p.mutex.Unlock()
+			continue
+		}
+		p.dialFailures++
+		p.mutex.Unlock()
+		logging.Or(p.option.Logger).Warn("rpc-client: fail to refill pool", logging.KeyAddr, p.addr, logging.KeyError, err)
+		select {
+		case <-p.done:
+			return
+		case <-time.After(jitter(interval)):
+		}
+		interval *= 2
+		if p.option.MaxReconnectInterval > 0 && interval > p.option.MaxReconnectInterval {
+			interval = p.option.MaxReconnectInterval
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+// flakyDialer dials addr until it is down, and keeps every connection it dialed.
+type flakyDialer struct {
+	mutex sync.Mutex
+	down  bool
+	conns []net.Conn
+}
+
+func (d *flakyDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.down {
+		return nil, errors.New("network is down")
+	}
+	conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+	if err == nil {
+		d.conns = append(d.conns, conn)
+	}
+	return conn, err
+}
+
+// cut takes the network down and closes every connection.
+func (d *flakyDialer) cut() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.down = true
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+	d.conns = nil
+}
+
+func (d *flakyDialer) restore() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.down = false
+}
+
+func TestPoolStats(t *testing.T) {
+	addr := client.Serve(t, Arith{Delay: 100 * time.Millisecond}, server.DefaultOption)
+	option := client.DefaultOption
+	option.PoolSize = 3
+	p, err := client.NewPooledClient(context.Background(), "tcp", addr, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	call := p.Go(context.Background(), "Arith.Add", Args{}, &Reply{}, nil)
+	time.Sleep(20 * time.Millisecond)
+	stats := p.Stats()
+	if stats.Addr != addr || stats.Size != 3 || stats.Conns != 3 || stats.Healthy != 3 || stats.Pending != 1 {
+		t.Fatalf("stats %+v with one call pending", stats)
+	}
+	<-call.Done
+	if stats := p.Stats(); stats.Pending != 0 {
+		t.Fatalf("stats %+v after the call", stats)
+	}
+}
+
+func TestPoolEvictsAndRefills(t *testing.T) {
+	addr := client.Serve(t, Arith{}, server.DefaultOption)
+	dialer := &flakyDialer{}
+	option := client.DefaultOption
+	option.PoolSize = 2
+	option.ReconnectInterval = 20 * time.Millisecond
+	option.MaxReconnectInterval = time.Second
+	option.Dialer = dialer.dial
+	p, err := client.NewPooledClient(context.Background(), "tcp", addr, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	dialer.cut()
+	time.Sleep(20 * time.Millisecond)
+	// both connections are reconnecting, the call evicts them
+	if err := p.Call(context.Background(), "Arith.Add", Args{}, &Reply{}); err != client.ErrorUnavailable {
+		t.Fatalf("got %v without connections, want %v", err, client.ErrorUnavailable)
+	}
+	if stats := p.Stats(); stats.Evicted != 2 || stats.Conns != 0 {
+		t.Fatalf("stats %+v, want 2 connections evicted", stats)
+	}
+	time.Sleep(300 * time.Millisecond)
+	// refill backs off from ReconnectInterval: 10-20ms, 20-40ms, 40-80ms, 80-160ms
+	if stats := p.Stats(); stats.DialFailures < 2 || stats.DialFailures > 6 {
+		t.Fatalf("stats %+v, want refill to back off", stats)
+	}
+
+	dialer.restore()
+	for i := 0; ; i++ {
+		if stats := p.Stats(); stats.Conns == 2 && stats.Healthy == 2 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("stats %+v, want the pool refilled", p.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.Call(context.Background(), "Arith.Add", Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+}
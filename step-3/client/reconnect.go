@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"github.com/huangw1/rpc-demo/step-3/transport"
+	"math/rand"
+	"time"
+)
+
+type ConnState int
+
+const (
+	StateConnected ConnState = iota
+	// StateReconnecting fails new calls fast with ErrorUnavailable while the connection is redialed in background
+	StateReconnecting
+	// StateDisconnected redials on the next call
+	StateDisconnected
+	StateShutdown
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateDisconnected:
+		return "disconnected"
+	case StateShutdown:
+		return "shutdown"
+	}
+	return "unknown"
+}
+
+// setState must be called with c.mutex held.
+func (c *simpleClient) setState(state ConnState) {
+	if c.state == StateConnected && state != StateConnected {
+		c.metrics.connClosed()
+	}
+	c.state = state
+	if c.option.OnStateChange != nil {
+		c.option.OnStateChange(c.addr, state)
+	}
+}
+
+// connected must be called with c.mutex held, or before c is shared.
+func (c *simpleClient) connected(t transport.Transport) {
+	t.SetKeepAlive(c.option.KeepAlivePeriod)
+	c.rwc = t
+	c.connectedAt = time.Now()
+	c.metrics.connOpened()
+	c.setState(StateConnected)
+	go c.input(t)
+}
+
+func (c *simpleClient) connState() ConnState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+// conn returns the connection to write call on and binds call to it, so losing the
+// connection only fails the calls written on it. A disconnected client is redialed
+// by one call at a time, the others wait for it, without holding c.mutex.
+func (c *simpleClient) conn(ctx context.Context, call *Call) (transport.Transport, error) {
+	for {
+		c.mutex.Lock()
+		switch c.state {
+		case StateConnected:
+			rwc := c.rwc
+			call.rwc = rwc
+			c.mutex.Unlock()
+			return rwc, nil
+		case StateReconnecting:
+			c.mutex.Unlock()
+			return nil, ErrorUnavailable
+		case StateShutdown:
+			c.mutex.Unlock()
+			return nil, ErrorShutdown
+		}
+		if dialing := c.dialing; dialing != nil {
+			c.mutex.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, &DialError{Addr: c.addr, Err: ctx.Err()}
+			}
+		}
+		dialing := make(chan struct{})
+		c.dialing = dialing
+		c.mutex.Unlock()
+		t, err := c.redial(ctx)
+		c.mutex.Lock()
+		c.dialing = nil
+		close(dialing)
+		if err != nil {
+			c.mutex.Unlock()
+			return nil, &DialError{Addr: c.addr, Err: err}
+		}
+		if c.state != StateDisconnected {
+			// closed while dialing
+			c.mutex.Unlock()
+			t.Close()
+			continue
+		}
+		c.connected(t)
+		c.mutex.Unlock()
+	}
+}
+
+func (c *simpleClient) connectionLost(rwc transport.Transport, err error) {
+	rwc.Close()
+	c.mutex.Lock()
+	if c.rwc == rwc && c.state == StateConnected {
+		c.logger.Warn("rpc-client: connection lost", logging.KeyAddr, c.addr, logging.KeyError, err)
+		if c.option.ReconnectInterval > 0 {
+			c.setState(StateReconnecting)
+			go c.reconnect()
+		} else {
+			c.setState(StateDisconnected)
+		}
+	}
+	var lost []uint64
+	c.pendingCalls.Range(func(key, value interface{}) bool {
+		if value.(*Call).rwc == rwc {
+			lost = append(lost, key.(uint64))
+		}
+		return true
+	})
+	c.mutex.Unlock()
+	for _, seq := range lost {
+		c.finish(seq, ErrorConnection)
+	}
+}
+
+func (c *simpleClient) reconnect() {
+	interval := c.option.ReconnectInterval
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(jitter(interval)):
+		}
+		t, err := c.redial(context.Background())
+		c.mutex.Lock()
+		if c.state != StateReconnecting {
+			c.mutex.Unlock()
+			if t != nil {
+				t.Close()
+			}
+			return
+		}
+		if err == nil {
+			c.connected(t)
+			c.mutex.Unlock()
+			c.logger.Info("rpc-client: reconnected", logging.KeyAddr, c.addr, "attempts", attempt)
+			return
+		}
+		c.mutex.Unlock()
+		c.logger.Warn("rpc-client: fail to reconnect", logging.KeyAddr, c.addr, "attempt", attempt, logging.KeyError, err)
+		interval *= 2
+		if c.option.MaxReconnectInterval > 0 && interval > c.option.MaxReconnectInterval {
+			interval = c.option.MaxReconnectInterval
+		}
+	}
+}
+
+// jitter spreads reconnects of many clients over [interval/2, interval].
+func jitter(interval time.Duration) time.Duration {
+	return interval/2 + time.Duration(rand.Int63n(int64(interval/2)+1))
+}
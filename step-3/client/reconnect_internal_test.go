@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+type Sleep struct{}
+
+func (Sleep) Sleep(ctx context.Context, ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func TestConnectionLostOnlyFailsItsCalls(t *testing.T) {
+	addr := serve(t, Sleep{}, server.DefaultOption)
+	option := DefaultOption
+	option.ReconnectInterval = 0
+	c, err := newClient(context.Background(), "tcp", addr, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	call := c.Go(context.Background(), "Sleep.Sleep", 200, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+	// another connection becomes the current one and is lost, the call was written on the first
+	other, err := dial(context.Background(), "tcp", addr, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mutex.Lock()
+	c.rwc = other
+	c.mutex.Unlock()
+	c.connectionLost(other, io.EOF)
+	<-call.Done
+	if call.Error != nil {
+		t.Fatal(call.Error)
+	}
+}
+
+// pipeDialer hands out one end of a pipe per dial and the messages written to it.
+type pipeDialer struct {
+	messages chan chan *protocol.Message
+	peers    []net.Conn
+}
+
+func (d *pipeDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, peer := net.Pipe()
+	d.peers = append(d.peers, peer)
+	messages := make(chan *protocol.Message, 10)
+	d.messages <- messages
+	go func() {
+		r := bufio.NewReader(peer)
+		for {
+			msg, err := protocol.DecodeMessage(DefaultOption.ProtocolType, r)
+			if err != nil {
+				return
+			}
+			messages <- msg
+		}
+	}()
+	return conn, nil
+}
+
+func TestCancelGoesOnTheCallsConnection(t *testing.T) {
+	dialer := &pipeDialer{messages: make(chan chan *protocol.Message, 2)}
+	defer func() {
+		for _, peer := range dialer.peers {
+			peer.Close()
+		}
+	}()
+	option := DefaultOption
+	option.Dialer = dialer.dial
+	c, err := newClient(context.Background(), "tcp", "10.0.0.1:7000", option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	first := <-dialer.messages
+	expect := func(messages chan *protocol.Message, messageType protocol.MessageType) *protocol.Message {
+		t.Helper()
+		select {
+		case msg := <-messages:
+			if msg.MessageType != messageType {
+				t.Fatalf("message type %d, want %d", msg.MessageType, messageType)
+			}
+			return msg
+		case <-time.After(time.Second):
+			t.Fatalf("no message of type %d", messageType)
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	c.Call(ctx, "Sleep.Sleep", 1000, new(int))
+	req := expect(first, protocol.MessageTypeReq)
+	if msg := expect(first, protocol.MessageTypeCancel); msg.Seq != req.Seq {
+		t.Fatalf("canceled seq %d, want %d", msg.Seq, req.Seq)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		c.Call(ctx, "Sleep.Sleep", 1000, new(int))
+		close(done)
+	}()
+	expect(first, protocol.MessageTypeReq)
+	// another connection becomes the current one before the call times out
+	other, err := dial(context.Background(), "tcp", "10.0.0.1:7000", option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := <-dialer.messages
+	c.mutex.Lock()
+	c.rwc = other
+	c.mutex.Unlock()
+	<-done
+	select {
+	case msg := <-first:
+		t.Fatalf("message %+v on the replaced connection", msg)
+	case msg := <-second:
+		t.Fatalf("message %+v on a connection that never carried the call", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,124 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func TestRedialDoesNotBlockClose(t *testing.T) {
+	addr := client.Serve(t, Arith{}, server.DefaultOption)
+	var mutex sync.Mutex
+	var conns []net.Conn
+	option := client.DefaultOption
+	option.ReconnectInterval = 0
+	option.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if len(conns) > 0 {
+			// black hole every redial
+			mutex.Unlock()
+			<-ctx.Done()
+			mutex.Lock()
+			return nil, ctx.Err()
+		}
+		conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+		if err == nil {
+			conns = append(conns, conn)
+		}
+		return conn, err
+	}
+	c, err := client.NewSimpleClient("tcp", addr, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	conns[0].Close()
+	mutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Call(ctx, "Arith.Add", Args{}, &Reply{})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	c.Close()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Close waited %s for the redial", elapsed)
+	}
+	if err := <-done; err != client.ErrorShutdown {
+		t.Fatalf("got %v, want the call failed by Close", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	addr := client.Serve(t, Arith{}, server.DefaultOption)
+	for _, interval := range []time.Duration{20 * time.Millisecond, 0} {
+		var mutex sync.Mutex
+		var conns []net.Conn
+		var states []client.ConnState
+		down := true
+		option := client.DefaultOption
+		option.ReconnectInterval = interval
+		option.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if down && len(conns) > 0 {
+				return nil, errors.New("network is down")
+			}
+			conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+			if err == nil {
+				conns = append(conns, conn)
+			}
+			return conn, err
+		}
+		option.OnStateChange = func(addr string, state client.ConnState) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			states = append(states, state)
+		}
+		c, err := client.NewSimpleClient("tcp", addr, option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		mutex.Lock()
+		conns[0].Close()
+		mutex.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		if err := c.Call(context.Background(), "Arith.Add", Args{}, &Reply{}); !client.NotSent(err) {
+			t.Fatalf("interval %s: got %v while down, want the call not sent", interval, err)
+		}
+		mutex.Lock()
+		down = false
+		mutex.Unlock()
+		var reply Reply
+		for i := 0; ; i++ {
+			err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &reply)
+			if err == nil {
+				break
+			}
+			if i == 100 {
+				t.Fatalf("interval %s: not reconnected: %v", interval, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if reply.C != 3 {
+			t.Fatalf("got %d, want 3", reply.C)
+		}
+		mutex.Lock()
+		if len(conns) != 2 || states[len(states)-1] != client.StateConnected {
+			t.Fatalf("interval %s: %d connections, states %v", interval, len(conns), states)
+		}
+		mutex.Unlock()
+	}
+}
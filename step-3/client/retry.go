@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"net"
+	"reflect"
+	"time"
+)
+
+type FailMode byte
+
+const (
+	FailFast FailMode = iota
+	// FailOver retries on another instance while there is one left
+	FailOver
+	// FailTry retries on the same instance
+	FailTry
+	// FailSafe fails over like FailOver and then returns the zero reply instead of the error
+	FailSafe
+)
+
+type RetryPolicy struct {
+	FailMode    FailMode
+	MaxAttempts int
+	// Backoff is doubled after every attempt up to MaxBackoff, with jitter
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Idempotent methods are also retried when the request may have reached the server
+	Idempotent bool
+	// Retryable replaces the default classifier
+	Retryable func(err error) bool
+}
+
+func (o Option) retryPolicy(serviceMethod string) RetryPolicy {
+	if policy, ok := o.MethodRetryPolicies[serviceMethod]; ok {
+		return policy
+	}
+	return o.RetryPolicy
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	if NotSent(err) || Rejected(err) {
+		return true
+	}
+	if !p.Idempotent {
+		return false
+	}
+	if err == ErrorTimeout || err == ErrorConnection {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// NotSent reports whether err happened before any byte of the request reached the server.
+func NotSent(err error) bool {
+	var dialErr *DialError
+	return err == ErrorUnavailable || err == ErrorCircuitOpen || err == ErrorThrottled || errors.As(err, &dialErr)
+}
+
+// Canceled reports whether the caller gave up on the request, e.g. a hedged request or
+// a Fork whose answer came from another instance, which says nothing about the instance.
+func Canceled(err error) bool {
+	return rpcerr.Code(err) == rpcerr.Canceled
+}
+
+// Rejected reports whether the server turned the request down before running it.
+func Rejected(err error) bool {
+	e, ok := rpcerr.FromError(err)
+	return ok && e.Code == rpcerr.ResourceExhausted
+}
+
+// retryAfter is the wait before the next attempt, at least the RetryInfo of the server.
+func retryAfter(err error, backoff time.Duration) time.Duration {
+	var info rpcerr.RetryInfo
+	if e, ok := rpcerr.FromError(err); ok && e.Detail(&info) && info.RetryDelay > backoff {
+		return info.RetryDelay
+	}
+	if backoff > 0 {
+		return jitter(backoff)
+	}
+	return 0
+}
+
+func (c *DiscoveryClient) callWithRetry(ctx context.Context, serviceName string, arg interface{}, reply interface{}) error {
+	policy := c.option.retryPolicy(serviceName)
+	attempts := policy.MaxAttempts
+	if attempts < 1 || policy.FailMode == FailFast {
+		attempts = 1
+	}
+	backoff := policy.Backoff
+	var instance registry.Instance
+	var stats *instanceStats
+	var tried []string
+	var err error
+	for attempt := 1; ; attempt++ {
+		if attempt == 1 || policy.FailMode != FailTry {
+			instance, stats, err = c.selectInstance(ctx, serviceName, arg, tried)
+			if err != nil {
+				break
+			}
+		}
+		var used registry.Instance
+		used, err = c.callHedged(ctx, instance, stats, serviceName, arg, reply)
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !policy.retryable(err) {
+			break
+		}
+		logging.Or(c.option.Logger).Warn("rpc-client: call failed, retrying", logging.KeyService, serviceName,
+			logging.KeyAddr, used.Addr, "attempt", attempt, "attempts", attempts, logging.KeyError, err)
+		tried = append(tried, used.Key())
+		if wait := retryAfter(err, backoff); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+		}
+		if backoff > 0 {
+			backoff *= 2
+			if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+		}
+	}
+	if err != nil && policy.FailMode == FailSafe {
+		logging.Or(c.option.Logger).Warn("rpc-client: call failed safe", logging.KeyService, serviceName, logging.KeyError, err)
+		zeroReply(reply)
+		return nil
+	}
+	return err
+}
+
+func zeroReply(reply interface{}) {
+	v := reflect.ValueOf(reply)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		if v.Elem().Kind() != reflect.Ptr {
+			v.Elem().Set(reflect.Zero(v.Elem().Type()))
+			return
+		}
+		v = v.Elem()
+	}
+}
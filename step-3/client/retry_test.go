@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+// Busy rejects its first fail calls as overloaded.
+type Busy struct {
+	fail  int32
+	calls *int32
+}
+
+func newBusy(fail int32) Busy {
+	return Busy{fail: fail, calls: new(int32)}
+}
+
+func (b Busy) Do(ctx context.Context, arg int, reply *int) error {
+	if atomic.AddInt32(b.calls, 1) <= b.fail {
+		return rpcerr.New(rpcerr.ResourceExhausted, "busy")
+	}
+	*reply = arg
+	return nil
+}
+
+func (b Busy) Calls() int32 {
+	return atomic.LoadInt32(b.calls)
+}
+
+func retryClient(t *testing.T, policy RetryPolicy, addrs ...string) *DiscoveryClient {
+	t.Helper()
+	option := DefaultOption
+	option.RetryPolicy = policy
+	option.Breaker = BreakerPolicy{}
+	option.Throttle = ThrottlePolicy{}
+	var instances []registry.Instance
+	for _, addr := range addrs {
+		instances = append(instances, registry.Instance{Service: "Busy", Network: "tcp", Addr: addr})
+	}
+	c, err := NewDiscoveryClient(registry.NewStaticDiscovery(instances...), option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestRetryable(t *testing.T) {
+	netErr := &net.OpError{Op: "read", Err: errors.New("connection reset")}
+	for _, tt := range []struct {
+		err        error
+		retryable  bool
+		idempotent bool
+	}{
+		{ErrorUnavailable, true, true},
+		{&DialError{Addr: "10.0.0.1:7000", Err: errors.New("refused")}, true, true},
+		{ErrorCircuitOpen, true, true},
+		{ErrorThrottled, true, true},
+		{rpcerr.New(rpcerr.ResourceExhausted, "busy"), true, true},
+		{ErrorTimeout, false, true},
+		{ErrorConnection, false, true},
+		{netErr, false, true},
+		{rpcerr.New(rpcerr.Internal, "handler failed"), false, false},
+		{errors.New("handler failed"), false, false},
+	} {
+		if got := (RetryPolicy{}).retryable(tt.err); got != tt.retryable {
+			t.Errorf("%v: retryable %t, want %t", tt.err, got, tt.retryable)
+		}
+		if got := (RetryPolicy{Idempotent: true}).retryable(tt.err); got != tt.idempotent {
+			t.Errorf("%v: retryable %t when idempotent, want %t", tt.err, got, tt.idempotent)
+		}
+	}
+	never := RetryPolicy{Retryable: func(err error) bool { return false }}
+	if never.retryable(ErrorUnavailable) {
+		t.Error("Retryable does not replace the default classifier")
+	}
+}
+
+func TestFailOver(t *testing.T) {
+	busy := newBusy(1 << 30)
+	idle := newBusy(0)
+	c := retryClient(t, RetryPolicy{FailMode: FailOver, MaxAttempts: 2},
+		serve(t, busy, server.DefaultOption), serve(t, idle, server.DefaultOption))
+	for i := 0; i < 10; i++ {
+		var reply int
+		if err := c.Call(context.Background(), "Busy.Do", 1, &reply); err != nil || reply != 1 {
+			t.Fatal(err, reply)
+		}
+	}
+	// every call the busy instance rejected went over to the idle one
+	if idle.Calls() != 10 {
+		t.Fatalf("idle instance got %d of 10 calls", idle.Calls())
+	}
+}
+
+func TestFailTry(t *testing.T) {
+	first := newBusy(2)
+	second := newBusy(2)
+	c := retryClient(t, RetryPolicy{FailMode: FailTry, MaxAttempts: 3},
+		serve(t, first, server.DefaultOption), serve(t, second, server.DefaultOption))
+	var reply int
+	if err := c.Call(context.Background(), "Busy.Do", 1, &reply); err != nil || reply != 1 {
+		t.Fatal(err, reply)
+	}
+	// all three attempts went to the same instance
+	if calls := []int32{first.Calls(), second.Calls()}; calls[0]+calls[1] != 3 || calls[0]*calls[1] != 0 {
+		t.Fatalf("instances got %v calls, want 3 on one of them", calls)
+	}
+}
+
+func TestFailSafe(t *testing.T) {
+	busy := newBusy(1 << 30)
+	c := retryClient(t, RetryPolicy{FailMode: FailSafe, MaxAttempts: 2, Backoff: time.Millisecond},
+		serve(t, busy, server.DefaultOption))
+	reply := 7
+	if err := c.Call(context.Background(), "Busy.Do", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != 0 {
+		t.Fatalf("reply %d, want the zero reply", reply)
+	}
+	if busy.Calls() != 2 {
+		t.Fatalf("%d attempts, want 2", busy.Calls())
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	busy := newBusy(1)
+	c := retryClient(t, RetryPolicy{FailMode: FailFast, MaxAttempts: 3}, serve(t, busy, server.DefaultOption))
+	if err := c.Call(context.Background(), "Busy.Do", 1, new(int)); !Rejected(err) {
+		t.Fatalf("got %v, want the rejection", err)
+	}
+	if busy.Calls() != 1 {
+		t.Fatalf("%d attempts, want 1", busy.Calls())
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"github.com/huangw1/rpc-demo/step-3/rpcz"
+	"sort"
+	"strings"
+	"time"
+)
+
+// clientRpcz is nil without Option.Rpcz, every method is then a no-op.
+type clientRpcz struct {
+	page   *rpcz.Page
+	c      *simpleClient
+	name   string
+	remove func()
+}
+
+func newClientRpcz(page *rpcz.Page, c *simpleClient) *clientRpcz {
+	if page == nil {
+		return nil
+	}
+	r := &clientRpcz{page: page, c: c, name: "client " + c.addr}
+	r.remove = page.Add(r)
+	return r
+}
+
+func (r *clientRpcz) close() {
+	if r != nil {
+		r.remove()
+	}
+}
+
+func (r *clientRpcz) end(call *Call) {
+	if r == nil {
+		return
+	}
+	info := rpczCall(call, r.c.addr)
+	info.Duration = time.Since(call.start)
+	code := rpcerr.Code(call.Error)
+	info.Code = code.String()
+	if call.Error != nil {
+		info.Error = call.Error.Error()
+	}
+	r.page.Record(r.name, info, code != rpcerr.OK)
+}
+
+func (r *clientRpcz) Snapshot() rpcz.Snapshot {
+	now := time.Now()
+	snapshot := rpcz.Snapshot{Name: r.name}
+	r.c.mutex.Lock()
+	if r.c.rwc != nil {
+		snapshot.Conns = append(snapshot.Conns, rpcz.Conn{
+			LocalAddr:  r.c.rwc.LocalAddr().String(),
+			RemoteAddr: r.c.rwc.RemoteAddr().String(),
+			State:      r.c.state.String(),
+			Since:      r.c.connectedAt,
+		})
+	}
+	r.c.mutex.Unlock()
+	r.c.pendingCalls.Range(func(key, value interface{}) bool {
+		info := rpczCall(value.(*Call), r.c.addr)
+		info.Duration = now.Sub(info.Start)
+		snapshot.Calls = append(snapshot.Calls, info)
+		return true
+	})
+	sort.Slice(snapshot.Calls, func(i, j int) bool {
+		return snapshot.Calls[i].Start.Before(snapshot.Calls[j].Start)
+	})
+	return snapshot
+}
+
+func rpczCall(call *Call, addr string) rpcz.Call {
+	serviceMethod := strings.SplitN(call.ServiceMethod, ".", 2)
+	info := rpcz.Call{Seq: call.seq, Service: serviceMethod[0], Addr: addr, Start: call.start}
+	if len(serviceMethod) == 2 {
+		info.Method = serviceMethod[1]
+	}
+	return info
+}
@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type SelectorType byte
+
+const (
+	RandomSelect SelectorType = iota
+	WeightedRoundRobinSelect
+	LeastOutstandingSelect
+	PowerOfTwoSelect
+	ConsistentHashSelect
+)
+
+// Selector picks the instance of every call of a DiscoveryClient.
+type Selector interface {
+	Name() string
+	// Select picks one of instances, which is never empty
+	Select(ctx context.Context, serviceMethod string, arg interface{}, instances []registry.Instance) registry.Instance
+	// Update is called whenever the instance list changes
+	Update(instances []registry.Instance)
+}
+
+// LoadFunc reports the outstanding requests of an instance.
+type LoadFunc func(instance registry.Instance) int64
+
+// HashKeyFunc returns the consistent hash key of a call.
+type HashKeyFunc func(ctx context.Context, serviceMethod string, arg interface{}) string
+
+func NewSelector(t SelectorType, load LoadFunc, hashKey HashKeyFunc) Selector {
+	switch t {
+	case WeightedRoundRobinSelect:
+		return &weightedRoundRobinSelector{}
+	case LeastOutstandingSelect:
+		return &leastOutstandingSelector{load: load}
+	case PowerOfTwoSelect:
+		return &powerOfTwoSelector{load: load}
+	case ConsistentHashSelect:
+		if hashKey == nil {
+			hashKey = DefaultHashKey
+		}
+		return &consistentHashSelector{hashKey: hashKey}
+	}
+	return randomSelector{}
+}
+
+// DefaultHashKey uses protocol.HashKey of the metadata and falls back to the argument.
+func DefaultHashKey(ctx context.Context, serviceMethod string, arg interface{}) string {
+	if metaData, ok := ctx.Value(protocol.MetaDataKey).(map[string]string); ok {
+		if key, ok := metaData[protocol.HashKey]; ok {
+			return key
+		}
+	}
+	return fmt.Sprintf("%v", arg)
+}
+
+type randomSelector struct {
+}
+
+func (randomSelector) Name() string {
+	return "random"
+}
+
+func (randomSelector) Select(ctx context.Context, serviceMethod string, arg interface{}, instances []registry.Instance) registry.Instance {
+	return instances[rand.Intn(len(instances))]
+}
+
+func (randomSelector) Update(instances []registry.Instance) {
+}
+
+// weightedRoundRobinSelector is the smooth weighted round-robin of nginx.
+type weightedRoundRobinSelector struct {
+	mutex   sync.Mutex
+	current map[string]int
+}
+
+func (s *weightedRoundRobinSelector) Name() string {
+	return "weighted-round-robin"
+}
+
+func (s *weightedRoundRobinSelector) Select(ctx context.Context, serviceMethod string, arg interface{}, instances []registry.Instance) registry.Instance {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.current == nil {
+		s.current = make(map[string]int)
+	}
+	total := 0
+	best := -1
+	for i, instance := range instances {
+		weight := instance.Weight()
+		total += weight
+		s.current[instance.Key()] += weight
+		if best < 0 || s.current[instance.Key()] > s.current[instances[best].Key()] {
+			best = i
+		}
+	}
+	s.current[instances[best].Key()] -= total
+	return instances[best]
+}
+
+func (s *weightedRoundRobinSelector) Update(instances []registry.Instance) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.current = make(map[string]int)
+}
+
+type leastOutstandingSelector struct {
+	load LoadFunc
+}
+
+func (s *leastOutstandingSelector) Name() string {
+	return "least-outstanding"
+}
+
+func (s *leastOutstandingSelector) Select(ctx context.Context, serviceMethod string, arg interface{}, instances []registry.Instance) registry.Instance {
+	// start at a random offset so ties do not all land on the first instance
+	offset := rand.Intn(len(instances))
+	picked := instances[offset]
+	least := s.load(picked)
+	for i := 1; i < len(instances); i++ {
+		instance := instances[(offset+i)%len(instances)]
+		if load := s.load(instance); load < least {
+			picked = instance
+			least = load
+		}
+	}
+	return picked
+}
+
+func (s *leastOutstandingSelector) Update(instances []registry.Instance) {
+}
+
+type powerOfTwoSelector struct {
+	load LoadFunc
+}
+
+func (s *powerOfTwoSelector) Name() string {
+	return "power-of-two-choices"
+}
+
+func (s *powerOfTwoSelector) Select(ctx context.Context, serviceMethod string, arg interface{}, instances []registry.Instance) registry.Instance {
+	if len(instances) == 1 {
+		return instances[0]
+	}
+	i := rand.Intn(len(instances))
+	j := rand.Intn(len(instances) - 1)
+	if j >= i {
+		j++
+	}
+	if s.load(instances[j]) < s.load(instances[i]) {
+		return instances[j]
+	}
+	return instances[i]
+}
+
+func (s *powerOfTwoSelector) Update(instances []registry.Instance) {
+}
+
+const hashReplicas = 100
+
+// consistentHashSelector places hashReplicas * weight virtual nodes of every instance on a crc32 ring.
+type consistentHashSelector struct {
+	hashKey HashKeyFunc
+	mutex   sync.RWMutex
+	ring    []uint32
+	nodes   map[uint32]registry.Instance
+}
+
+func (s *consistentHashSelector) Name() string {
+	return "consistent-hash"
+}
+
+func (s *consistentHashSelector) Select(ctx context.Context, serviceMethod string, arg interface{}, instances []registry.Instance) registry.Instance {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(s.ring) == 0 {
+		return instances[0]
+	}
+	hash := crc32.ChecksumIEEE([]byte(s.hashKey(ctx, serviceMethod, arg)))
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i] >= hash
+	})
+	candidates := make(map[string]registry.Instance, len(instances))
+	for _, instance := range instances {
+		candidates[instance.Key()] = instance
+	}
+	// walk the ring past the nodes of instances left out, e.g. with their breaker open or tried
+	for n := 0; n < len(s.ring); n++ {
+		if instance, ok := candidates[s.nodes[s.ring[(i+n)%len(s.ring)]].Key()]; ok {
+			return instance
+		}
+	}
+	return instances[0]
+}
+
+func (s *consistentHashSelector) Update(instances []registry.Instance) {
+	ring := make([]uint32, 0, len(instances)*hashReplicas)
+	nodes := make(map[uint32]registry.Instance)
+	for _, instance := range instances {
+		for i := 0; i < hashReplicas*instance.Weight(); i++ {
+			hash := crc32.ChecksumIEEE([]byte(instance.Key() + "#" + strconv.Itoa(i)))
+			if _, ok := nodes[hash]; ok {
+				continue
+			}
+			nodes[hash] = instance
+			ring = append(ring, hash)
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i] < ring[j]
+	})
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ring = ring
+	s.nodes = nodes
+}
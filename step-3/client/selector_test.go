@@ -0,0 +1,60 @@
+package client_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func TestConsistentHashSelectsAmongInstances(t *testing.T) {
+	all := []registry.Instance{
+		{Network: "tcp", Addr: "10.0.0.1:7000"},
+		{Network: "tcp", Addr: "10.0.0.2:7000"},
+		{Network: "tcp", Addr: "10.0.0.3:7000"},
+	}
+	s := client.NewSelector(client.ConsistentHashSelect, nil, client.DefaultHashKey)
+	s.Update(all)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		first := s.Select(context.Background(), "Arith.Add", key, all)
+		if again := s.Select(context.Background(), "Arith.Add", key, all); again.Key() != first.Key() {
+			t.Fatalf("key %s moved from %s to %s", key, first.Addr, again.Addr)
+		}
+		for _, instance := range all {
+			if instance.Key() == first.Key() {
+				continue
+			}
+			if got := s.Select(context.Background(), "Arith.Add", key, []registry.Instance{instance}); got.Key() != instance.Key() {
+				t.Fatalf("key %s selected %s, only %s is left", key, got.Addr, instance.Addr)
+			}
+		}
+	}
+}
+
+func TestConsistentHashFailsOver(t *testing.T) {
+	alive := client.Serve(t, Arith{}, server.DefaultOption)
+	option := client.DefaultOption
+	option.SelectorType = client.ConsistentHashSelect
+	c := newDiscoveryClient(t, option, alive, deadAddr(t))
+	for i := 0; i < 40; i++ {
+		if err := c.Call(context.Background(), "Arith.Add", Args{A: i}, &Reply{}); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+// deadAddr is a local address nothing listens on.
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
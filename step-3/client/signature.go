@@ -0,0 +1,33 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"strconv"
+	"time"
+)
+
+// SigningKey is a key shared with the server, which finds it by ID.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// sign adds a timestamp, a random nonce and the signature of req under key to its metadata.
+func sign(req *protocol.Message, key *SigningKey) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	metaData := make(map[string]string, len(req.MetaData)+4)
+	for k, v := range req.MetaData {
+		metaData[k] = v
+	}
+	metaData[protocol.SignatureKeyIDKey] = key.ID
+	metaData[protocol.SignatureTimestampKey] = strconv.FormatInt(time.Now().UnixNano(), 10)
+	metaData[protocol.SignatureNonceKey] = hex.EncodeToString(nonce)
+	req.MetaData = metaData
+	req.MetaData[protocol.SignatureKey] = protocol.Signature(req, key.Secret)
+	return nil
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrorThrottled = rpcerr.New(rpcerr.Unavailable, "rpc-client: request dropped by adaptive throttling")
+
+// ThrottlePolicy drops requests to an instance locally with probability
+// (requests - K*accepts) / (requests + 1), both counted over Window, once the
+// instance rejects requests with protocol.StatusResourceExhausted. A K of 2 lets
+// through about twice as many requests as the instance accepts, zero disables it.
+type ThrottlePolicy struct {
+	K      float64
+	Window time.Duration
+}
+
+func (p ThrottlePolicy) enabled() bool {
+	return p.K > 0 && p.Window > 0
+}
+
+const throttleBuckets = 10
+
+type throttleBucket struct {
+	start    time.Time
+	requests uint64
+	accepts  uint64
+}
+
+// throttle counts requests and accepts in throttleBuckets buckets sliding over the window.
+type throttle struct {
+	policy  ThrottlePolicy
+	mutex   sync.Mutex
+	buckets [throttleBuckets]throttleBucket
+	dropped uint64
+}
+
+func newThrottle(policy ThrottlePolicy) *throttle {
+	return &throttle{policy: policy}
+}
+
+// allow drops a request at the current drop rate, a dropped request counts as rejected.
+func (t *throttle) allow() bool {
+	if !t.policy.enabled() {
+		return true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if rand.Float64() >= t.dropRate() {
+		return true
+	}
+	t.bucket().requests++
+	t.dropped++
+	return false
+}
+
+// record counts a request that reached the instance, accepted unless the instance rejected it.
+func (t *throttle) record(err error) {
+	if !t.policy.enabled() || NotSent(err) || Canceled(err) {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	bucket := t.bucket()
+	bucket.requests++
+	if !Rejected(err) {
+		bucket.accepts++
+	}
+}
+
+// DropRate is the probability the next request is dropped.
+func (t *throttle) DropRate() float64 {
+	if !t.policy.enabled() {
+		return 0
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.dropRate()
+}
+
+func (t *throttle) Dropped() uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.dropped
+}
+
+// dropRate must be called with t.mutex held.
+func (t *throttle) dropRate() float64 {
+	var requests, accepts uint64
+	since := time.Now().Add(-t.policy.Window)
+	for _, bucket := range t.buckets {
+		if bucket.start.After(since) {
+			requests += bucket.requests
+			accepts += bucket.accepts
+		}
+	}
+	return math.Max(0, (float64(requests)-t.policy.K*float64(accepts))/float64(requests+1))
+}
+
+// bucket must be called with t.mutex held.
+func (t *throttle) bucket() *throttleBucket {
+	width := t.policy.Window / throttleBuckets
+	now := time.Now()
+	start := now.Truncate(width)
+	bucket := &t.buckets[int(now.UnixNano()/int64(width))%throttleBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = throttleBucket{start: start}
+	}
+	return bucket
+}
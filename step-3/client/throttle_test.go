@@ -0,0 +1,70 @@
+package client
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+)
+
+func TestThrottleDropRate(t *testing.T) {
+	th := newThrottle(ThrottlePolicy{K: 2, Window: time.Minute})
+	rejected := rpcerr.New(rpcerr.ResourceExhausted, "busy")
+	for i := 0; i < 10; i++ {
+		th.record(nil)
+	}
+	for i := 0; i < 30; i++ {
+		th.record(rejected)
+	}
+	// max(0, (requests - K*accepts) / (requests + 1)) with 40 requests and 10 accepts
+	if rate, want := th.DropRate(), (40.0-2*10)/41; math.Abs(rate-want) > 1e-9 {
+		t.Fatalf("drop rate %f, want %f", rate, want)
+	}
+	healthy := newThrottle(ThrottlePolicy{K: 2, Window: time.Minute})
+	healthy.record(nil)
+	healthy.record(rejected)
+	if rate := healthy.DropRate(); rate != 0 {
+		t.Fatalf("drop rate %f with requests below K*accepts, want 0", rate)
+	}
+}
+
+func TestThrottleRecordSkipsRequestsNotSent(t *testing.T) {
+	th := newThrottle(ThrottlePolicy{K: 1, Window: time.Minute})
+	th.record(rpcerr.New(rpcerr.ResourceExhausted, "busy"))
+	rate := th.DropRate()
+	for _, err := range []error{ErrorUnavailable, ErrorCircuitOpen, &DialError{Addr: "10.0.0.1:7000", Err: ErrorUnavailable}, ErrorCanceled} {
+		th.record(err)
+	}
+	if th.DropRate() != rate {
+		t.Fatalf("drop rate went from %f to %f on requests that did not reach the instance", rate, th.DropRate())
+	}
+}
+
+func TestThrottleCountsDropsAsRequests(t *testing.T) {
+	th := newThrottle(ThrottlePolicy{K: 1, Window: time.Minute})
+	for i := 0; i < 100; i++ {
+		th.record(rpcerr.New(rpcerr.ResourceExhausted, "busy"))
+	}
+	for i := 0; i < 1000 && th.Dropped() == 0; i++ {
+		th.allow()
+	}
+	if th.Dropped() == 0 {
+		t.Fatal("no request dropped at a drop rate near 1")
+	}
+	// every drop is a request without an accept: (100 + dropped) / (101 + dropped)
+	want := float64(100+th.Dropped()) / float64(101+th.Dropped())
+	if rate := th.DropRate(); math.Abs(rate-want) > 1e-9 {
+		t.Fatalf("drop rate %f after %d drops, want %f", rate, th.Dropped(), want)
+	}
+}
+
+func TestThrottleDisabled(t *testing.T) {
+	th := newThrottle(ThrottlePolicy{})
+	for i := 0; i < 100; i++ {
+		th.record(rpcerr.New(rpcerr.ResourceExhausted, "busy"))
+		if !th.allow() {
+			t.Fatal("a zero policy dropped a request")
+		}
+	}
+}
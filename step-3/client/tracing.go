@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/huangw1/rpc-demo/step-3/client"
+
+type clientTracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func newClientTracing(option Option) *clientTracing {
+	provider := option.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	propagator := option.Propagator
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+	return &clientTracing{tracer: provider.Tracer(instrumentationName), propagator: propagator}
+}
+
+// start opens the span of call and injects its context into the metadata of req.
+func (t *clientTracing) start(ctx context.Context, call *Call, req *protocol.Message, addr string) {
+	service, method := splitServiceMethod(call.ServiceMethod)
+	_, call.span = t.tracer.Start(ctx, service+"/"+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "rpc-demo"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", method),
+			attribute.String("server.address", addr),
+		))
+	metaData := make(map[string]string, len(req.MetaData)+2)
+	for k, v := range req.MetaData {
+		metaData[k] = v
+	}
+	t.propagator.Inject(trace.ContextWithSpan(ctx, call.span), propagation.MapCarrier(metaData))
+	req.MetaData = metaData
+}
+
+func (t *clientTracing) end(call *Call) {
+	if call.span == nil {
+		return
+	}
+	code := rpcerr.Code(call.Error)
+	call.span.SetAttributes(attribute.String("rpc.status_code", code.String()))
+	if call.Error != nil {
+		call.span.SetStatus(codes.Error, call.Error.Error())
+	}
+	call.span.End()
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"flag"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"log"
+	"net/http"
+)
+
+func main() {
+	addr := flag.String("addr", ":8500", "listen address")
+	flag.Parse()
+	r := registry.NewMemoryRegistry()
+	log.Printf("rpc-registry: listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, registry.NewHTTPRegistryServer(r)))
+}
@@ -0,0 +1,55 @@
+// Package logging is the structured logging of the server and client.
+package logging
+
+import (
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Logger takes a message and key value pairs, *slog.Logger is one.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Or is logger, or slog.Default() when it is nil.
+func Or(logger Logger) Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// Keys of the fields server and client logs share.
+const (
+	KeyRemoteAddr = "remote_addr"
+	KeyAddr       = "addr"
+	KeySeq        = "seq"
+	KeyService    = "service"
+	KeyMethod     = "method"
+	KeyCode       = "code"
+	KeyError      = "error"
+	KeyDuration   = "duration"
+)
+
+// AccessLog logs a line per request. Failed requests and requests slower than
+// SlowThreshold are always logged, others with probability SampleRate, zero logs all.
+type AccessLog struct {
+	Enabled       bool
+	SampleRate    float64
+	SlowThreshold time.Duration
+}
+
+// Sample reports whether to log a request.
+func (a AccessLog) Sample(failed bool, duration time.Duration) bool {
+	if !a.Enabled {
+		return false
+	}
+	if failed || (a.SlowThreshold > 0 && duration >= a.SlowThreshold) {
+		return true
+	}
+	return a.SampleRate <= 0 || rand.Float64() < a.SampleRate
+}
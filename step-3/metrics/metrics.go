@@ -0,0 +1,340 @@
+// Package metrics keeps counters, gauges and histograms with labels
+// and serves them over http in the prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultLatencyBuckets are in seconds, DefaultSizeBuckets in bytes.
+var DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+var DefaultSizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+
+var DefaultRegistry = NewRegistry()
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// Registry holds metric families by name. Asking for a family twice returns the same
+// one, so several servers and clients can share a registry.
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+	mutex   sync.RWMutex
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	metric      interface{}
+}
+
+func (r *Registry) family(name, help string, typ metricType, buckets []float64, labels []string) *family {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || len(f.labels) != len(labels) {
+			panic("metrics: " + name + " is already registered as another metric")
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(labelValues []string, newMetric func() interface{}) interface{} {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mutex.RLock()
+	s, ok := f.series[key]
+	f.mutex.RUnlock()
+	if ok {
+		return s.metric
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if s, ok := f.series[key]; ok {
+		return s.metric
+	}
+	s = &series{labelValues: append([]string(nil), labelValues...), metric: newMetric()}
+	f.series[key] = s
+	return s.metric
+}
+
+type CounterVec struct {
+	family *family
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{family: r.family(name, help, counterType, nil, labels)}
+}
+
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.family.with(labelValues, func() interface{} {
+		return new(Counter)
+	}).(*Counter)
+}
+
+type GaugeVec struct {
+	family *family
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{family: r.family(name, help, gaugeType, nil, labels)}
+}
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.family.with(labelValues, func() interface{} {
+		return new(Gauge)
+	}).(*Gauge)
+}
+
+type HistogramVec struct {
+	family *family
+}
+
+// NewHistogramVec counts observations in buckets by upper bound, which must be sorted.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{family: r.family(name, help, histogramType, buckets, labels)}
+}
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.family.with(labelValues, func() interface{} {
+		return &Histogram{buckets: v.family.buckets, counts: make([]uint64, len(v.family.buckets))}
+	}).(*Histogram)
+}
+
+type Counter struct {
+	bits uint64
+}
+
+// Add panics on negative values, counters only go up.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter can not decrease")
+	}
+	addFloat(&c.bits, v)
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	addFloat(&g.bits, v)
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func addFloat(bits *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		if atomic.CompareAndSwapUint64(bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+type Histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// snapshot returns cumulative bucket counts.
+func (h *Histogram) snapshot() ([]uint64, float64, uint64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	cumulative := make([]uint64, len(h.counts))
+	var total uint64
+	for i, count := range h.counts {
+		total += count
+		cumulative[i] = total
+	}
+	return cumulative, h.sum, h.count
+}
+
+// WriteTo writes every metric in the prometheus text format, sorted by name and labels.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+	err := cw.w.(*bufio.Writer).Flush()
+	if cw.err != nil {
+		err = cw.err
+	}
+	return cw.n, err
+}
+
+func (f *family) write(w *countingWriter) {
+	f.mutex.RLock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mutex.RUnlock()
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labelValues, "\xff") < strings.Join(all[j].labelValues, "\xff")
+	})
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	for _, s := range all {
+		labels := f.formatLabels(s.labelValues)
+		switch metric := s.metric.(type) {
+		case *Counter:
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatFloat(metric.Value()))
+		case *Gauge:
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatFloat(metric.Value()))
+		case *Histogram:
+			counts, sum, count := metric.snapshot()
+			for i, bound := range f.buckets {
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.formatLabels(s.labelValues, "le", formatFloat(bound)), counts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.formatLabels(s.labelValues, "le", "+Inf"), count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatFloat(sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, count)
+		}
+	}
+}
+
+// formatLabels formats the labels of a series, extra is one more name and value.
+func (f *family) formatLabels(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, f.labels[i]+`="`+escapeLabel(value)+`"`)
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, extra[0]+`="`+extra[1]+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+// ServeHTTP serves the registry for prometheus to scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"encoding/binary"
 	"github.com/vmihailenco/msgpack"
+	"strconv"
 )
 
 /**
@@ -20,6 +21,16 @@ const (
 	RequestSeqKey     = "rpc_request_seq"
 	RequestTimeoutKey = "rpc_request_timeout"
 	MetaDataKey       = "rpc_meta_data"
+	// HashKey in the request metadata is the key of consistent hash load balancing
+	HashKey = "rpc_hash_key"
+	// AuthorizationKey in the request metadata carries BearerPrefix and the token of the caller
+	AuthorizationKey = "authorization"
+	BearerPrefix     = "Bearer "
+)
+
+const (
+	DefaultRPCPath = "/_rpc_http_"
+	HTTPConnected  = "200 Connected to RPC"
 )
 
 type MessageType byte
@@ -27,6 +38,8 @@ type MessageType byte
 const (
 	MessageTypeReq MessageType = iota
 	MessageTypeRes
+	// MessageTypeCancel tells the server the client has given up on the request with the same Seq
+	MessageTypeCancel
 )
 
 type CompressType byte
@@ -37,11 +50,59 @@ const (
 
 type StatusCode byte
 
+// Status codes take the names of the grpc codes but not their numbers, StatusOk and
+// StatusError keep the values they had on the wire. StatusError is grpc Unknown, the
+// code of errors without a more specific one.
 const (
 	StatusOk    StatusCode = iota
 	StatusError
+	// StatusResourceExhausted rejects a request over the server limits
+	StatusResourceExhausted
+	// StatusUnauthenticated rejects a request without valid credentials
+	StatusUnauthenticated
+	// StatusPermissionDenied rejects a request the caller is not allowed to make
+	StatusPermissionDenied
+	StatusCanceled
+	StatusInvalidArgument
+	StatusDeadlineExceeded
+	StatusNotFound
+	StatusAlreadyExists
+	StatusFailedPrecondition
+	StatusAborted
+	StatusOutOfRange
+	StatusUnimplemented
+	StatusInternal
+	StatusUnavailable
+	StatusDataLoss
 )
 
+var statusNames = map[StatusCode]string{
+	StatusOk:                 "OK",
+	StatusError:              "Unknown",
+	StatusResourceExhausted:  "ResourceExhausted",
+	StatusUnauthenticated:    "Unauthenticated",
+	StatusPermissionDenied:   "PermissionDenied",
+	StatusCanceled:           "Canceled",
+	StatusInvalidArgument:    "InvalidArgument",
+	StatusDeadlineExceeded:   "DeadlineExceeded",
+	StatusNotFound:           "NotFound",
+	StatusAlreadyExists:      "AlreadyExists",
+	StatusFailedPrecondition: "FailedPrecondition",
+	StatusAborted:            "Aborted",
+	StatusOutOfRange:         "OutOfRange",
+	StatusUnimplemented:      "Unimplemented",
+	StatusInternal:           "Internal",
+	StatusUnavailable:        "Unavailable",
+	StatusDataLoss:           "DataLoss",
+}
+
+func (c StatusCode) String() string {
+	if name, ok := statusNames[c]; ok {
+		return name
+	}
+	return "Code(" + strconv.Itoa(int(c)) + ")"
+}
+
 type ProtocolType byte
 
 const (
@@ -74,6 +135,14 @@ type Header struct {
 	MethodName    string
 	Error         string
 	MetaData      map[string]string
+	// ErrorDetails are the structured details of Error, see package rpcerr
+	ErrorDetails []ErrorDetail `msgpack:",omitempty"`
+}
+
+// ErrorDetail is Value encoded with msgpack, Type is the go type name of Value.
+type ErrorDetail struct {
+	Type  string
+	Value []byte
 }
 
 type Message struct {
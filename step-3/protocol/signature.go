@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+	"strconv"
+)
+
+const (
+	// SignatureKeyIDKey, SignatureTimestampKey (unix nanoseconds) and SignatureNonceKey
+	// in the request metadata are covered by the signature in SignatureKey
+	SignatureKeyIDKey     = "rpc_signature_key_id"
+	SignatureTimestampKey = "rpc_signature_timestamp"
+	SignatureNonceKey     = "rpc_signature_nonce"
+	SignatureKey          = "rpc_signature"
+)
+
+// Signature is the hex encoded hmac-sha256 under key of the request header,
+// every metadata entry but SignatureKey included, and the body.
+func Signature(m *Message, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	writeField(mac, strconv.FormatUint(m.Seq, 10))
+	mac.Write([]byte{byte(m.MessageType), byte(m.CompressType), byte(m.SerializeType)})
+	writeField(mac, m.ServiceName)
+	writeField(mac, m.MethodName)
+	keys := make([]string, 0, len(m.MetaData))
+	for k := range m.MetaData {
+		if k != SignatureKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeField(mac, k)
+		writeField(mac, m.MetaData[k])
+	}
+	mac.Write(m.Data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// writeField writes s with its length, so no two field lists hash alike.
+func writeField(h hash.Hash, s string) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(s)))
+	h.Write(length[:])
+	h.Write([]byte(s))
+}
@@ -0,0 +1,114 @@
+package registry_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/registry"
+)
+
+// next returns the next list of w, failing after a second.
+func next(t *testing.T, w registry.Watcher) []registry.Instance {
+	t.Helper()
+	result := make(chan []registry.Instance, 1)
+	go func() {
+		instances, _ := w.Next()
+		result <- instances
+	}()
+	select {
+	case instances := <-result:
+		return instances
+	case <-time.After(time.Second):
+		t.Fatal("no change delivered to the watcher")
+		return nil
+	}
+}
+
+func TestStaticDiscoveryWatch(t *testing.T) {
+	a := registry.Instance{Network: "tcp", Addr: "10.0.0.1:7000"}
+	b := registry.Instance{Network: "tcp", Addr: "10.0.0.2:7000"}
+	d := registry.NewStaticDiscovery(a)
+	w := d.Watch()
+	d.Update(a)
+	d.Update(a, b)
+	// a slow watcher only sees the latest list
+	d.Update(b)
+	if instances := next(t, w); !reflect.DeepEqual(instances, []registry.Instance{b}) {
+		t.Fatalf("got %v, want %v", instances, []registry.Instance{b})
+	}
+	if instances, _ := d.Instances(); !reflect.DeepEqual(instances, []registry.Instance{b}) {
+		t.Fatalf("got %v, want %v", instances, []registry.Instance{b})
+	}
+	d.Close()
+	if _, err := w.Next(); err != registry.ErrorWatcherClosed {
+		t.Fatalf("got %v after Close, want %v", err, registry.ErrorWatcherClosed)
+	}
+}
+
+func TestFileDiscoveryReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instances.json")
+	write := func(content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`[{"network": "tcp", "addr": "10.0.0.1:7000"}]`)
+	d, err := registry.NewFileDiscovery(path, 10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if instances, _ := d.Instances(); len(instances) != 1 || instances[0].Addr != "10.0.0.1:7000" {
+		t.Fatalf("got %v", instances)
+	}
+	w := d.Watch()
+	// the expired entry is skipped
+	write(`[{"network": "tcp", "addr": "10.0.0.2:7000"}, {"network": "tcp", "addr": "10.0.0.3:7000", "expire": "2000-01-01T00:00:00Z"}]`)
+	if instances := next(t, w); len(instances) != 1 || instances[0].Addr != "10.0.0.2:7000" {
+		t.Fatalf("got %v", instances)
+	}
+}
+
+func TestFileRegistryConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		// a registry per writer, like separate processes sharing the file
+		r := registry.NewFileRegistry(path)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				instance := registry.Instance{Service: "Arith", Network: "tcp", Addr: "10.0.0." + strconv.Itoa(i) + ":" + strconv.Itoa(7000+j)}
+				if err := r.Register(instance, time.Minute); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	r := registry.NewFileRegistry(path)
+	last := registry.Instance{Service: "Arith", Network: "tcp", Addr: "10.0.1.0:7000"}
+	if err := r.Register(last, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	d, err := r.Discovery("Arith", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	instances, _ := d.Instances()
+	for _, instance := range instances {
+		if instance.Key() == last.Key() {
+			return
+		}
+	}
+	t.Fatalf("%v misses %v", instances, last)
+}
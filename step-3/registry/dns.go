@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSDiscovery polls the SRV records of _service._proto.name, priority and weight
+// of every record are kept in the instance metadata. Weighted selectors use the
+// weight, the priority is ignored: every record is an instance of equal rank.
+type DNSDiscovery struct {
+	service  string
+	proto    string
+	name     string
+	interval time.Duration
+	logger   logging.Logger
+	hub      watchHub
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewDNSDiscovery logs failed lookups to logger, slog.Default() when nil.
+func NewDNSDiscovery(service, proto, name string, interval time.Duration, logger logging.Logger) (*DNSDiscovery, error) {
+	d := new(DNSDiscovery)
+	d.service = service
+	d.proto = proto
+	d.name = name
+	d.interval = interval
+	d.logger = logging.Or(logger)
+	if d.interval <= 0 {
+		d.interval = time.Second * 30
+	}
+	d.done = make(chan struct{})
+	instances, err := d.lookup()
+	if err != nil {
+		return nil, err
+	}
+	d.hub.set(instances)
+	go d.poll()
+	return d, nil
+}
+
+func (d *DNSDiscovery) lookup() ([]Instance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.interval)
+	defer cancel()
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, d.service, d.proto, d.name)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]Instance, 0, len(records))
+	for _, record := range records {
+		instances = append(instances, Instance{
+			Network: "tcp",
+			Addr:    net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))),
+			MetaData: map[string]string{
+				PriorityKey: strconv.Itoa(int(record.Priority)),
+				WeightKey:   strconv.Itoa(int(record.Weight)),
+			},
+		})
+	}
+	// the resolver shuffles records of equal priority by weight on every lookup
+	sortInstances(instances)
+	return instances, nil
+}
+
+func (d *DNSDiscovery) poll() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+		instances, err := d.lookup()
+		if err != nil {
+			d.logger.Warn("rpc-registry: fail to lookup srv", logging.KeyService, d.service, "proto", d.proto, "name", d.name, logging.KeyError, err)
+			continue
+		}
+		d.hub.set(instances)
+	}
+}
+
+func (d *DNSDiscovery) Instances() ([]Instance, error) {
+	return d.hub.get(), nil
+}
+
+func (d *DNSDiscovery) Watch() Watcher {
+	return d.hub.watch()
+}
+
+func (d *DNSDiscovery) Close() error {
+	d.once.Do(func() {
+		close(d.done)
+		d.hub.close()
+	})
+	return nil
+}
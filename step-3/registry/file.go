@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"encoding/json"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FileDiscovery reads the instance list from a json or yaml file (by extension)
+// and reloads it whenever the file changes. Entries with an expire time in the
+// past, as written by FileRegistry, are skipped.
+type FileDiscovery struct {
+	path     string
+	service  string
+	interval time.Duration
+	logger   logging.Logger
+	modTime  time.Time
+	size     int64
+	entries  []entry
+	hub      watchHub
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewFileDiscovery logs failed reloads to logger, slog.Default() when nil.
+func NewFileDiscovery(path string, interval time.Duration, logger logging.Logger) (*FileDiscovery, error) {
+	return newFileDiscovery(path, "", interval, logger)
+}
+
+func newFileDiscovery(path string, service string, interval time.Duration, logger logging.Logger) (*FileDiscovery, error) {
+	d := new(FileDiscovery)
+	d.path = path
+	d.service = service
+	d.interval = interval
+	d.logger = logging.Or(logger)
+	if d.interval <= 0 {
+		d.interval = time.Second * 5
+	}
+	d.done = make(chan struct{})
+	err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	go d.poll()
+	return d, nil
+}
+
+func (d *FileDiscovery) load() error {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	if !info.ModTime().Equal(d.modTime) || info.Size() != d.size {
+		entries, err := readEntries(d.path)
+		if err != nil {
+			return err
+		}
+		d.modTime = info.ModTime()
+		d.size = info.Size()
+		d.entries = entries
+	}
+	var instances []Instance
+	now := time.Now()
+	for _, e := range d.entries {
+		if d.service != "" && e.Service != d.service {
+			continue
+		}
+		if !e.Expire.IsZero() && now.After(e.Expire) {
+			continue
+		}
+		instances = append(instances, e.Instance)
+	}
+	d.hub.set(instances)
+	return nil
+}
+
+func readEntries(path string) ([]entry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []entry
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &entries)
+	default:
+		err = json.Unmarshal(data, &entries)
+	}
+	return entries, err
+}
+
+func (d *FileDiscovery) poll() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+		err := d.load()
+		if err != nil {
+			d.logger.Warn("rpc-registry: fail to reload", "path", d.path, logging.KeyError, err)
+		}
+	}
+}
+
+func (d *FileDiscovery) Instances() ([]Instance, error) {
+	return d.hub.get(), nil
+}
+
+func (d *FileDiscovery) Watch() Watcher {
+	return d.hub.watch()
+}
+
+func (d *FileDiscovery) Close() error {
+	d.once.Do(func() {
+		close(d.done)
+		d.hub.close()
+	})
+	return nil
+}
+
+// FileRegistry keeps the instances of every service in one json file,
+// so several local processes can find each other without a registry service.
+// Concurrent writers may drop an entry, the next heartbeat puts it back.
+type FileRegistry struct {
+	// Logger is slog.Default() when nil, its discoveries log failed reloads through it
+	Logger logging.Logger
+	path   string
+	mutex  sync.Mutex
+}
+
+func NewFileRegistry(path string) *FileRegistry {
+	return &FileRegistry{path: path}
+}
+
+func (r *FileRegistry) Register(instance Instance, ttl time.Duration) error {
+	return r.update(func(entries []entry) []entry {
+		entries = removeEntry(entries, instance)
+		return append(entries, entry{Instance: instance, Expire: time.Now().Add(ttl)})
+	})
+}
+
+func (r *FileRegistry) Deregister(instance Instance) error {
+	return r.update(func(entries []entry) []entry {
+		return removeEntry(entries, instance)
+	})
+}
+
+func (r *FileRegistry) Discovery(service string, interval time.Duration) (*FileDiscovery, error) {
+	// create the file when no server has registered yet
+	err := r.update(func(entries []entry) []entry {
+		return entries
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newFileDiscovery(r.path, service, interval, r.Logger)
+}
+
+func (r *FileRegistry) update(fn func(entries []entry) []entry) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entries, err := readEntries(r.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	alive := entries[:0]
+	now := time.Now()
+	for _, e := range entries {
+		if e.Expire.IsZero() || now.Before(e.Expire) {
+			alive = append(alive, e)
+		}
+	}
+	data, err := json.MarshalIndent(fn(alive), "", "  ")
+	if err != nil {
+		return err
+	}
+	// a temp file of its own per writer, so no writer renames a file another one is writing
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+func removeEntry(entries []entry, instance Instance) []entry {
+	key := entryKey(instance)
+	kept := entries[:0]
+	for _, e := range entries {
+		if entryKey(e.Instance) != key {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
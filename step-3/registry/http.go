@@ -0,0 +1,167 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HTTPRegistryServer serves a MemoryRegistry over http:
+//
+//	POST /register    {"instance": {...}, "ttl": nanoseconds}
+//	POST /deregister  {...}
+//	GET  /instances?service=name
+type HTTPRegistryServer struct {
+	registry *MemoryRegistry
+}
+
+type registerRequest struct {
+	Instance Instance      `json:"instance"`
+	TTL      time.Duration `json:"ttl"`
+}
+
+func NewHTTPRegistryServer(registry *MemoryRegistry) *HTTPRegistryServer {
+	return &HTTPRegistryServer{registry: registry}
+}
+
+func (s *HTTPRegistryServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/register" && req.Method == http.MethodPost:
+		var body registerRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.registry.Register(body.Instance, body.TTL)
+	case req.URL.Path == "/deregister" && req.Method == http.MethodPost:
+		var instance Instance
+		if err := json.NewDecoder(req.Body).Decode(&instance); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.registry.Deregister(instance)
+	case req.URL.Path == "/instances" && req.Method == http.MethodGet:
+		instances := s.registry.Instances(req.URL.Query().Get("service"))
+		if instances == nil {
+			instances = []Instance{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(instances)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// HTTPRegistry talks to an HTTPRegistryServer at addr, e.g. "http://127.0.0.1:8500".
+type HTTPRegistry struct {
+	// Logger is slog.Default() when nil, its discoveries log failed polls through it
+	Logger logging.Logger
+	addr   string
+	client *http.Client
+}
+
+func NewHTTPRegistry(addr string) *HTTPRegistry {
+	return &HTTPRegistry{addr: addr, client: &http.Client{Timeout: time.Second * 5}}
+}
+
+func (r *HTTPRegistry) Register(instance Instance, ttl time.Duration) error {
+	return r.post("/register", registerRequest{Instance: instance, TTL: ttl})
+}
+
+func (r *HTTPRegistry) Deregister(instance Instance) error {
+	return r.post("/deregister", instance)
+}
+
+func (r *HTTPRegistry) post(path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	res, err := r.client.Post(r.addr+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("rpc-registry: " + path + " " + res.Status)
+	}
+	return nil
+}
+
+func (r *HTTPRegistry) instances(service string) ([]Instance, error) {
+	res, err := r.client.Get(r.addr + "/instances?service=" + url.QueryEscape(service))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("rpc-registry: /instances " + res.Status)
+	}
+	var instances []Instance
+	err = json.NewDecoder(res.Body).Decode(&instances)
+	return instances, err
+}
+
+// Discovery polls the instances of service every interval.
+func (r *HTTPRegistry) Discovery(service string, interval time.Duration) (Discovery, error) {
+	d := &httpDiscovery{registry: r, service: service, interval: interval, logger: logging.Or(r.Logger), done: make(chan struct{})}
+	if d.interval <= 0 {
+		d.interval = time.Second * 5
+	}
+	instances, err := r.instances(service)
+	if err != nil {
+		return nil, err
+	}
+	d.hub.set(instances)
+	go d.poll()
+	return d, nil
+}
+
+type httpDiscovery struct {
+	registry *HTTPRegistry
+	service  string
+	interval time.Duration
+	logger   logging.Logger
+	hub      watchHub
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (d *httpDiscovery) poll() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+		instances, err := d.registry.instances(d.service)
+		if err != nil {
+			d.logger.Warn("rpc-registry: fail to fetch instances", logging.KeyService, d.service, logging.KeyError, err)
+			continue
+		}
+		d.hub.set(instances)
+	}
+}
+
+func (d *httpDiscovery) Instances() ([]Instance, error) {
+	return d.hub.get(), nil
+}
+
+func (d *httpDiscovery) Watch() Watcher {
+	return d.hub.watch()
+}
+
+func (d *httpDiscovery) Close() error {
+	d.once.Do(func() {
+		close(d.done)
+		d.hub.close()
+	})
+	return nil
+}
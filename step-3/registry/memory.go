@@ -0,0 +1,137 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryRegistry keeps instances in process, Discovery(service) follows one service.
+type MemoryRegistry struct {
+	mutex   sync.Mutex
+	entries map[string]entry
+	hubs    map[string]*watchHub
+	done    chan struct{}
+	once    sync.Once
+}
+
+type entry struct {
+	Instance `yaml:",inline"`
+	Expire   time.Time `json:"expire" yaml:"expire"`
+}
+
+func entryKey(instance Instance) string {
+	return instance.Service + "/" + instance.Key()
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	r := new(MemoryRegistry)
+	r.entries = make(map[string]entry)
+	r.hubs = make(map[string]*watchHub)
+	r.done = make(chan struct{})
+	go r.expire()
+	return r
+}
+
+func (r *MemoryRegistry) Register(instance Instance, ttl time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries[entryKey(instance)] = entry{Instance: instance, Expire: time.Now().Add(ttl)}
+	r.notify(instance.Service)
+	return nil
+}
+
+func (r *MemoryRegistry) Deregister(instance Instance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.entries, entryKey(instance))
+	r.notify(instance.Service)
+	return nil
+}
+
+func (r *MemoryRegistry) Instances(service string) []Instance {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.instances(service)
+}
+
+func (r *MemoryRegistry) instances(service string) []Instance {
+	var instances []Instance
+	for _, e := range r.entries {
+		if e.Service == service {
+			instances = append(instances, e.Instance)
+		}
+	}
+	sortInstances(instances)
+	return instances
+}
+
+// notify must be called with r.mutex held.
+func (r *MemoryRegistry) notify(service string) {
+	if hub, ok := r.hubs[service]; ok {
+		hub.set(r.instances(service))
+	}
+}
+
+func (r *MemoryRegistry) hub(service string) *watchHub {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	hub, ok := r.hubs[service]
+	if !ok {
+		hub = new(watchHub)
+		hub.set(r.instances(service))
+		r.hubs[service] = hub
+	}
+	return hub
+}
+
+func (r *MemoryRegistry) Discovery(service string) Discovery {
+	return &memoryDiscovery{hub: r.hub(service)}
+}
+
+func (r *MemoryRegistry) expire() {
+	ticker := time.NewTicker(time.Millisecond * 500)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.mutex.Lock()
+			for key, e := range r.entries {
+				if now.After(e.Expire) {
+					delete(r.entries, key)
+					r.notify(e.Service)
+				}
+			}
+			r.mutex.Unlock()
+		}
+	}
+}
+
+func (r *MemoryRegistry) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for _, hub := range r.hubs {
+			hub.close()
+		}
+	})
+	return nil
+}
+
+type memoryDiscovery struct {
+	hub *watchHub
+}
+
+func (d *memoryDiscovery) Instances() ([]Instance, error) {
+	return d.hub.get(), nil
+}
+
+func (d *memoryDiscovery) Watch() Watcher {
+	return d.hub.watch()
+}
+
+func (d *memoryDiscovery) Close() error {
+	return nil
+}
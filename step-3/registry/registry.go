@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrorWatcherClosed = errors.New("rpc-registry: watcher is closed")
+
+const (
+	PriorityKey = "priority"
+	WeightKey   = "weight"
+)
+
+type Instance struct {
+	Service  string            `json:"service,omitempty" yaml:"service,omitempty"`
+	Network  string            `json:"network" yaml:"network"`
+	Addr     string            `json:"addr" yaml:"addr"`
+	MetaData map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+func (i Instance) Key() string {
+	return i.Network + "@" + i.Addr
+}
+
+// Weight reads WeightKey from the metadata, missing or invalid weights count as 1.
+func (i Instance) Weight() int {
+	weight, err := strconv.Atoi(i.MetaData[WeightKey])
+	if err != nil || weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// Registry is where servers publish their instances, one per service.
+type Registry interface {
+	// Register adds or refreshes the instance, it expires after ttl without refresh
+	Register(instance Instance, ttl time.Duration) error
+	Deregister(instance Instance) error
+}
+
+type Discovery interface {
+	Instances() ([]Instance, error)
+	Watch() Watcher
+	Close() error
+}
+
+type Watcher interface {
+	// Next blocks until the instance list changes and returns the whole new list
+	Next() ([]Instance, error)
+	Close()
+}
+
+// watchHub holds the latest instance list and fans changes out to watchers,
+// a slow watcher only ever sees the latest list.
+type watchHub struct {
+	mutex     sync.Mutex
+	instances []Instance
+	watchers  map[*watcher]struct{}
+	closed    bool
+}
+
+func (h *watchHub) get() []Instance {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Instance(nil), h.instances...)
+}
+
+func (h *watchHub) set(instances []Instance) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if reflect.DeepEqual(h.instances, instances) {
+		return
+	}
+	h.instances = append([]Instance(nil), instances...)
+	for w := range h.watchers {
+		select {
+		case <-w.ch:
+		default:
+		}
+		w.ch <- append([]Instance(nil), h.instances...)
+	}
+}
+
+func (h *watchHub) watch() Watcher {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	w := &watcher{hub: h, ch: make(chan []Instance, 1), done: make(chan struct{})}
+	if h.closed {
+		close(w.done)
+		return w
+	}
+	if h.watchers == nil {
+		h.watchers = make(map[*watcher]struct{})
+	}
+	h.watchers[w] = struct{}{}
+	return w
+}
+
+func (h *watchHub) close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.closed = true
+	for w := range h.watchers {
+		close(w.done)
+	}
+	h.watchers = nil
+}
+
+type watcher struct {
+	hub  *watchHub
+	ch   chan []Instance
+	done chan struct{}
+}
+
+func (w *watcher) Next() ([]Instance, error) {
+	select {
+	case instances := <-w.ch:
+		return instances, nil
+	case <-w.done:
+		return nil, ErrorWatcherClosed
+	}
+}
+
+func (w *watcher) Close() {
+	w.hub.mutex.Lock()
+	defer w.hub.mutex.Unlock()
+	if _, ok := w.hub.watchers[w]; ok {
+		delete(w.hub.watchers, w)
+		close(w.done)
+	}
+}
+
+func sortInstances(instances []Instance) {
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Key() < instances[j].Key()
+	})
+}
@@ -0,0 +1,29 @@
+package registry
+
+// StaticDiscovery serves a fixed instance list, Update replaces it by hand.
+type StaticDiscovery struct {
+	hub watchHub
+}
+
+func NewStaticDiscovery(instances ...Instance) *StaticDiscovery {
+	d := new(StaticDiscovery)
+	d.hub.set(instances)
+	return d
+}
+
+func (d *StaticDiscovery) Instances() ([]Instance, error) {
+	return d.hub.get(), nil
+}
+
+func (d *StaticDiscovery) Update(instances ...Instance) {
+	d.hub.set(instances)
+}
+
+func (d *StaticDiscovery) Watch() Watcher {
+	return d.hub.watch()
+}
+
+func (d *StaticDiscovery) Close() error {
+	d.hub.close()
+	return nil
+}
@@ -0,0 +1,153 @@
+// Package rpcerr carries typed errors from handlers to clients: a status code,
+// a message and structured details, which arrive at the client as an *Error again.
+package rpcerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"reflect"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	OK                 = protocol.StatusOk
+	Unknown            = protocol.StatusError
+	ResourceExhausted  = protocol.StatusResourceExhausted
+	Unauthenticated    = protocol.StatusUnauthenticated
+	PermissionDenied   = protocol.StatusPermissionDenied
+	Canceled           = protocol.StatusCanceled
+	InvalidArgument    = protocol.StatusInvalidArgument
+	DeadlineExceeded   = protocol.StatusDeadlineExceeded
+	NotFound           = protocol.StatusNotFound
+	AlreadyExists      = protocol.StatusAlreadyExists
+	FailedPrecondition = protocol.StatusFailedPrecondition
+	Aborted            = protocol.StatusAborted
+	OutOfRange         = protocol.StatusOutOfRange
+	Unimplemented      = protocol.StatusUnimplemented
+	Internal           = protocol.StatusInternal
+	Unavailable        = protocol.StatusUnavailable
+	DataLoss           = protocol.StatusDataLoss
+)
+
+type Error struct {
+	Code    protocol.StatusCode
+	Message string
+	Details []protocol.ErrorDetail
+}
+
+// New returns an error with code and msg, every detail is encoded with msgpack
+// and can be read back with Detail, e.g. a RetryInfo or a BadRequest.
+func New(code protocol.StatusCode, msg string, details ...interface{}) *Error {
+	e := &Error{Code: code, Message: msg}
+	for _, detail := range details {
+		value, err := msgpack.Marshal(detail)
+		if err != nil {
+			continue
+		}
+		e.Details = append(e.Details, protocol.ErrorDetail{Type: typeName(detail), Value: value})
+	}
+	return e
+}
+
+func Errorf(code protocol.StatusCode, format string, args ...interface{}) *Error {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+// Error is the message alone, so errors keep their text across the wire,
+// or the code when there is no message.
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return e.Message
+}
+
+// Detail decodes the first detail of the type v points to into v.
+func (e *Error) Detail(v interface{}) bool {
+	name := typeName(v)
+	for _, detail := range e.Details {
+		if detail.Type == name {
+			return msgpack.Unmarshal(detail.Value, v) == nil
+		}
+	}
+	return false
+}
+
+func typeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
+// RetryInfo tells the client how long to back off before a retry.
+type RetryInfo struct {
+	RetryDelay time.Duration
+}
+
+// BadRequest lists the invalid fields of an InvalidArgument error.
+type BadRequest struct {
+	FieldViolations []FieldViolation
+}
+
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// ErrorInfo is a machine readable reason of an error.
+type ErrorInfo struct {
+	Reason   string
+	Domain   string
+	MetaData map[string]string
+}
+
+// FromError finds the *Error in the chain of err. Context errors and errors with an
+// RPCError method convert to their code, other errors are not typed.
+func FromError(err error) (*Error, bool) {
+	if err == nil {
+		return nil, false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	var typed interface{ RPCError() *Error }
+	if errors.As(err, &typed) {
+		return typed.RPCError(), true
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(DeadlineExceeded, err.Error()), true
+	case errors.Is(err, context.Canceled):
+		return New(Canceled, err.Error()), true
+	}
+	return nil, false
+}
+
+// Convert is FromError with Unknown for untyped errors, nil for nil.
+func Convert(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := FromError(err); ok {
+		return e
+	}
+	return New(Unknown, err.Error())
+}
+
+// Code is OK for nil and Unknown for untyped errors.
+func Code(err error) protocol.StatusCode {
+	if err == nil {
+		return OK
+	}
+	return Convert(err).Code
+}
@@ -0,0 +1,222 @@
+// Package rpcz serves a debug page of the servers and clients sharing a Page:
+// registered services, live connections, in-flight calls and recent slow or failed calls.
+package rpcz
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var DefaultPage = NewPage(100, time.Second)
+
+type Method struct {
+	Name      string `json:"name"`
+	ArgType   string `json:"arg_type"`
+	ReplyType string `json:"reply_type"`
+}
+
+type Service struct {
+	Name    string   `json:"name"`
+	Methods []Method `json:"methods"`
+}
+
+type Conn struct {
+	LocalAddr  string    `json:"local_addr"`
+	RemoteAddr string    `json:"remote_addr"`
+	State      string    `json:"state,omitempty"`
+	Since      time.Time `json:"since"`
+}
+
+// Call is an in-flight call, whose Duration is its age, or a finished one.
+type Call struct {
+	Seq      uint64        `json:"seq"`
+	Service  string        `json:"service"`
+	Method   string        `json:"method"`
+	Addr     string        `json:"addr"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Code     string        `json:"code,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Snapshot is the state of a server or a client, Calls are pending on a client.
+type Snapshot struct {
+	Name     string    `json:"name"`
+	Services []Service `json:"services,omitempty"`
+	Conns    []Conn    `json:"conns"`
+	Calls    []Call    `json:"calls"`
+}
+
+// Source is a server or a client shown on the page.
+type Source interface {
+	Snapshot() Snapshot
+}
+
+// Recent is a finished call that was slow or failed.
+type Recent struct {
+	Source string `json:"source"`
+	Call
+}
+
+// Page keeps the sources added to it and the last calls slower than its threshold
+// or failed, so several servers and clients can share a page.
+type Page struct {
+	mutex         sync.Mutex
+	slowThreshold time.Duration
+	sources       map[int]Source
+	nextID        int
+	recent        []Recent
+	next          int
+}
+
+// NewPage keeps the last size slow or failed calls, a call is slow
+// from slowThreshold on and never when it is zero.
+func NewPage(size int, slowThreshold time.Duration) *Page {
+	if size < 1 {
+		size = 1
+	}
+	return &Page{
+		slowThreshold: slowThreshold,
+		sources:       make(map[int]Source),
+		recent:        make([]Recent, 0, size),
+	}
+}
+
+// Add shows source on the page until remove is called.
+func (p *Page) Add(source Source) (remove func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	id := p.nextID
+	p.nextID++
+	p.sources[id] = source
+	return func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		delete(p.sources, id)
+	}
+}
+
+// Record keeps a finished call of source if it failed or was slow.
+func (p *Page) Record(source string, call Call, failed bool) {
+	if !failed && (p.slowThreshold <= 0 || call.Duration < p.slowThreshold) {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	r := Recent{Source: source, Call: call}
+	if len(p.recent) < cap(p.recent) {
+		p.recent = append(p.recent, r)
+		return
+	}
+	p.recent[p.next] = r
+	p.next = (p.next + 1) % len(p.recent)
+}
+
+// Snapshots returns the sources in the order they were added.
+func (p *Page) Snapshots() []Snapshot {
+	p.mutex.Lock()
+	ids := make([]int, 0, len(p.sources))
+	for id := range p.sources {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	sources := make([]Source, 0, len(ids))
+	for _, id := range ids {
+		sources = append(sources, p.sources[id])
+	}
+	p.mutex.Unlock()
+	snapshots := make([]Snapshot, 0, len(sources))
+	for _, source := range sources {
+		snapshots = append(snapshots, source.Snapshot())
+	}
+	return snapshots
+}
+
+// Recent returns the recent slow and failed calls, the latest first.
+func (p *Page) Recent() []Recent {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	recent := make([]Recent, 0, len(p.recent))
+	for i := 0; i < len(p.recent); i++ {
+		recent = append(recent, p.recent[(p.next+len(p.recent)-1-i)%len(p.recent)])
+	}
+	return recent
+}
+
+// WriteTo writes the page as text.
+func (p *Page) WriteTo(w io.Writer) (int64, error) {
+	now := time.Now()
+	cw := &countingWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 4, 2, ' ', 0)
+	for _, s := range p.Snapshots() {
+		fmt.Fprintf(tw, "%s\n", s.Name)
+		if len(s.Services) > 0 {
+			fmt.Fprintf(tw, "  services (%d)\n", len(s.Services))
+			for _, service := range s.Services {
+				for _, m := range service.Methods {
+					fmt.Fprintf(tw, "    %s.%s\t%s\t%s\n", service.Name, m.Name, m.ArgType, m.ReplyType)
+				}
+			}
+		}
+		fmt.Fprintf(tw, "  connections (%d)\n", len(s.Conns))
+		for _, c := range s.Conns {
+			fmt.Fprintf(tw, "    %s -> %s\t%s\tup %s\n", c.LocalAddr, c.RemoteAddr, c.State, round(now.Sub(c.Since)))
+		}
+		fmt.Fprintf(tw, "  in-flight calls (%d)\n", len(s.Calls))
+		for _, c := range s.Calls {
+			fmt.Fprintf(tw, "    seq %d\t%s.%s\t%s\tage %s\n", c.Seq, c.Service, c.Method, c.Addr, round(now.Sub(c.Start)))
+		}
+		fmt.Fprintln(tw)
+	}
+	recent := p.Recent()
+	fmt.Fprintf(tw, "recent slow and failed calls (%d)\n", len(recent))
+	for _, r := range recent {
+		fmt.Fprintf(tw, "  %s\t%s\tseq %d\t%s.%s\t%s\t%s\t%s\t%s\n", r.Start.Format("2006-01-02 15:04:05.000"), r.Source,
+			r.Seq, r.Service, r.Method, r.Addr, round(r.Duration), r.Code, r.Error)
+	}
+	tw.Flush()
+	return cw.n, cw.err
+}
+
+// ServeHTTP writes the page as text, or as json with ?format=json.
+func (p *Page) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Sources []Snapshot `json:"sources"`
+			Recent  []Recent   `json:"recent"`
+		}{p.Snapshots(), p.Recent()})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	p.WriteTo(w)
+}
+
+func round(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
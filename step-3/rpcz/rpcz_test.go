@@ -0,0 +1,89 @@
+package rpcz_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/rpcz"
+)
+
+type source string
+
+func (s source) Snapshot() rpcz.Snapshot {
+	return rpcz.Snapshot{Name: string(s)}
+}
+
+func names(p *rpcz.Page) []string {
+	var names []string
+	for _, s := range p.Snapshots() {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestSnapshotsInOrderAdded(t *testing.T) {
+	p := rpcz.NewPage(10, time.Second)
+	removeA := p.Add(source("a"))
+	p.Add(source("b"))
+	removeC := p.Add(source("c"))
+	if got := names(p); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("got %v", got)
+	}
+	removeA()
+	removeC()
+	p.Add(source("d"))
+	if got := names(p); !reflect.DeepEqual(got, []string{"b", "d"}) {
+		t.Fatalf("got %v after removing a and c", got)
+	}
+}
+
+func TestRecentKeepsTheLastSlowOrFailedCalls(t *testing.T) {
+	p := rpcz.NewPage(3, 10*time.Millisecond)
+	for seq := uint64(1); seq <= 5; seq++ {
+		p.Record("server", rpcz.Call{Seq: seq, Duration: 20 * time.Millisecond}, false)
+		// fast and successful, not kept
+		p.Record("server", rpcz.Call{Seq: 100 + seq, Duration: time.Millisecond}, false)
+	}
+	p.Record("client", rpcz.Call{Seq: 6, Duration: time.Millisecond}, true)
+	var seqs []uint64
+	for _, r := range p.Recent() {
+		seqs = append(seqs, r.Seq)
+	}
+	if !reflect.DeepEqual(seqs, []uint64{6, 5, 4}) {
+		t.Fatalf("recent calls %v, want the latest 3 first", seqs)
+	}
+	if r := p.Recent()[0]; r.Source != "client" {
+		t.Fatalf("source %q, want client", r.Source)
+	}
+
+	// without a threshold only failures are kept
+	p = rpcz.NewPage(3, 0)
+	p.Record("server", rpcz.Call{Seq: 1, Duration: time.Hour}, false)
+	if recent := p.Recent(); len(recent) != 0 {
+		t.Fatalf("kept %v without a slow threshold", recent)
+	}
+}
+
+func TestServeJSON(t *testing.T) {
+	p := rpcz.NewPage(10, time.Second)
+	p.Add(source("server"))
+	p.Record("server", rpcz.Call{Seq: 1, Service: "Arith", Method: "Add", Code: "Internal"}, true)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/rpcz?format=json", nil))
+	var page struct {
+		Sources []rpcz.Snapshot `json:"sources"`
+		Recent  []rpcz.Recent   `json:"recent"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Sources) != 1 || page.Sources[0].Name != "server" {
+		t.Fatalf("sources %+v", page.Sources)
+	}
+	if len(page.Recent) != 1 || page.Recent[0].Method != "Add" || page.Recent[0].Code != "Internal" {
+		t.Fatalf("recent %+v", page.Recent)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"strings"
+)
+
+var ErrorUnauthenticated = rpcerr.New(rpcerr.Unauthenticated, "rpc-server: unauthenticated")
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name     string
+	Roles    []string
+	MetaData map[string]string
+}
+
+// Authenticator checks the bearer token of every request before dispatch,
+// token is empty when the request carries none.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+type AuthenticatorFunc func(ctx context.Context, token string) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	return f(ctx, token)
+}
+
+// TokenAuthenticator accepts a fixed set of opaque tokens.
+type TokenAuthenticator map[string]*Principal
+
+func (a TokenAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if principal, ok := a[token]; ok && token != "" {
+		return principal, nil
+	}
+	return nil, ErrorUnauthenticated
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the caller authenticated by Option.Authenticator.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+func (s *simpleServer) authenticate(ctx context.Context, req *protocol.Message) (context.Context, error) {
+	authorization := req.MetaData[protocol.AuthorizationKey]
+	token := strings.TrimPrefix(authorization, protocol.BearerPrefix)
+	if token == authorization {
+		token = ""
+	}
+	principal, err := s.option.Authenticator.Authenticate(ctx, token)
+	if err != nil {
+		return ctx, err
+	}
+	if principal == nil {
+		return ctx, ErrorUnauthenticated
+	}
+	return context.WithValue(ctx, principalKey{}, principal), nil
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ErrorPermissionDenied = rpcerr.New(rpcerr.PermissionDenied, "rpc-server: permission denied")
+
+// Authorizer decides whether principal may call a method, principal is nil
+// when the server has no Authenticator.
+type Authorizer interface {
+	Authorize(ctx context.Context, principal *Principal, info *UnaryInfo) error
+}
+
+// ACLRule allows the principals named in Principals, or having one of Roles,
+// to call the methods matching one of Methods. Names and methods are path.Match
+// patterns on "Service.Method", e.g. "Arith.*", and "*" matches everyone.
+type ACLRule struct {
+	Principals []string `json:"principals" yaml:"principals"`
+	Roles      []string `json:"roles" yaml:"roles"`
+	Methods    []string `json:"methods" yaml:"methods"`
+}
+
+// ACL denies every call no rule allows.
+type ACL struct {
+	Rules []ACLRule `json:"rules" yaml:"rules"`
+}
+
+func (a *ACL) Authorize(ctx context.Context, principal *Principal, info *UnaryInfo) error {
+	if principal == nil {
+		principal = &Principal{}
+	}
+	serviceMethod := info.ServiceName + "." + info.MethodName
+	for _, rule := range a.Rules {
+		if matchAny(rule.Methods, serviceMethod) && (matchAny(rule.Principals, principal.Name) || matchRoles(rule.Roles, principal.Roles)) {
+			return nil
+		}
+	}
+	return ErrorPermissionDenied
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRoles(patterns []string, roles []string) bool {
+	for _, role := range roles {
+		if matchAny(patterns, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileACL is an ACL read from a json or yaml file (by extension) and reloaded whenever
+// the file changes. A file that fails to load keeps the rules loaded before.
+type FileACL struct {
+	path     string
+	interval time.Duration
+	logger   logging.Logger
+	mutex    sync.RWMutex
+	acl      *ACL
+	modTime  time.Time
+	size     int64
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewFileACL logs failed reloads to logger, slog.Default() when nil.
+func NewFileACL(path string, interval time.Duration, logger logging.Logger) (*FileACL, error) {
+	a := new(FileACL)
+	a.path = path
+	a.interval = interval
+	a.logger = logging.Or(logger)
+	if a.interval <= 0 {
+		a.interval = time.Second * 5
+	}
+	a.done = make(chan struct{})
+	err := a.Reload()
+	if err != nil {
+		return nil, err
+	}
+	go a.poll()
+	return a, nil
+}
+
+// Reload reads the file again if it has changed.
+func (a *FileACL) Reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	a.mutex.RLock()
+	changed := !info.ModTime().Equal(a.modTime) || info.Size() != a.size
+	a.mutex.RUnlock()
+	if !changed {
+		return nil
+	}
+	acl, err := readACL(a.path)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.acl = acl
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+	return nil
+}
+
+func readACL(file string) (*ACL, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	acl := new(ACL)
+	switch filepath.Ext(file) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, acl)
+	default:
+		err = json.Unmarshal(data, acl)
+	}
+	return acl, err
+}
+
+func (a *FileACL) poll() {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+		err := a.Reload()
+		if err != nil {
+			a.logger.Warn("rpc-server: fail to reload acl", "path", a.path, logging.KeyError, err)
+		}
+	}
+}
+
+func (a *FileACL) Authorize(ctx context.Context, principal *Principal, info *UnaryInfo) error {
+	a.mutex.RLock()
+	acl := a.acl
+	a.mutex.RUnlock()
+	return acl.Authorize(ctx, principal, info)
+}
+
+func (a *FileACL) Close() error {
+	a.once.Do(func() {
+		close(a.done)
+	})
+	return nil
+}
+
+// AuditEvent is one authorization decision.
+type AuditEvent struct {
+	Time        time.Time
+	Principal   string
+	RemoteAddr  net.Addr
+	ServiceName string
+	MethodName  string
+	Allowed     bool
+	Reason      string
+}
+
+// AuditSink receives every authorization decision, it is called on the request goroutine.
+type AuditSink interface {
+	Audit(event AuditEvent)
+}
+
+type AuditSinkFunc func(event AuditEvent)
+
+func (f AuditSinkFunc) Audit(event AuditEvent) {
+	f(event)
+}
+
+// LogAuditSink writes every decision to Logger, slog.Default() when nil.
+type LogAuditSink struct {
+	Logger logging.Logger
+}
+
+func (l LogAuditSink) Audit(event AuditEvent) {
+	args := []interface{}{
+		"principal", event.Principal,
+		logging.KeyRemoteAddr, event.RemoteAddr,
+		logging.KeyService, event.ServiceName,
+		logging.KeyMethod, event.MethodName,
+		"allowed", event.Allowed,
+	}
+	if !event.Allowed {
+		args = append(args, "reason", event.Reason)
+	}
+	logging.Or(l.Logger).Info("rpc-server: audit", args...)
+}
+
+func (s *simpleServer) authorize(ctx context.Context, remoteAddr net.Addr, req *protocol.Message) error {
+	principal, _ := PrincipalFromContext(ctx)
+	info := &UnaryInfo{ServiceName: req.ServiceName, MethodName: req.MethodName}
+	err := s.option.Authorizer.Authorize(ctx, principal, info)
+	if s.option.AuditSink != nil {
+		event := AuditEvent{
+			Time:        time.Now(),
+			RemoteAddr:  remoteAddr,
+			ServiceName: req.ServiceName,
+			MethodName:  req.MethodName,
+			Allowed:     err == nil,
+		}
+		if principal != nil {
+			event.Principal = principal.Name
+		}
+		if err != nil {
+			event.Reason = err.Error()
+		}
+		s.option.AuditSink.Audit(event)
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package server_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func TestACL(t *testing.T) {
+	acl := &server.ACL{Rules: []server.ACLRule{
+		{Principals: []string{"alice"}, Methods: []string{"Arith.*"}},
+		{Roles: []string{"admin"}, Methods: []string{"*"}},
+		{Principals: []string{"*"}, Methods: []string{"Health.Check"}},
+	}}
+	for _, tt := range []struct {
+		principal *server.Principal
+		method    string
+		allowed   bool
+	}{
+		{&server.Principal{Name: "alice"}, "Arith.Add", true},
+		{&server.Principal{Name: "alice"}, "Admin.Reset", false},
+		{&server.Principal{Name: "bob"}, "Arith.Add", false},
+		{&server.Principal{Name: "bob", Roles: []string{"admin"}}, "Admin.Reset", true},
+		{&server.Principal{Name: "bob"}, "Health.Check", true},
+		{nil, "Health.Check", true},
+		{nil, "Arith.Add", false},
+	} {
+		serviceMethod := strings.SplitN(tt.method, ".", 2)
+		info := &server.UnaryInfo{ServiceName: serviceMethod[0], MethodName: serviceMethod[1]}
+		err := acl.Authorize(context.Background(), tt.principal, info)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%+v calling %s: got %v, want allowed %t", tt.principal, tt.method, err, tt.allowed)
+		}
+		if err != nil && rpcerr.Code(err) != rpcerr.PermissionDenied {
+			t.Errorf("%+v calling %s: got %v, want PermissionDenied", tt.principal, tt.method, err)
+		}
+	}
+}
+
+func TestFileACLReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("rules:\n- principals: [alice]\n  methods: [Arith.*]\n")
+	acl, err := server.NewFileACL(file, 10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acl.Close()
+	alice := &server.Principal{Name: "alice"}
+	bob := &server.Principal{Name: "bob"}
+	info := &server.UnaryInfo{ServiceName: "Arith", MethodName: "Add"}
+	if err := acl.Authorize(context.Background(), alice, info); err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.Authorize(context.Background(), bob, info); err == nil {
+		t.Fatal("bob is not in the acl")
+	}
+
+	write("rules:\n- principals: [bob]\n  methods: [Arith.*]\n")
+	waitFor(t, func() bool {
+		return acl.Authorize(context.Background(), bob, info) == nil
+	})
+	if err := acl.Authorize(context.Background(), alice, info); err == nil {
+		t.Fatal("alice was removed from the acl")
+	}
+
+	// a broken file keeps the rules loaded before
+	write("rules: [")
+	time.Sleep(50 * time.Millisecond)
+	if err := acl.Authorize(context.Background(), bob, info); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	var mutex sync.Mutex
+	var events []server.AuditEvent
+	so := server.DefaultOption
+	so.Authenticator = server.TokenAuthenticator{"a": {Name: "alice"}, "b": {Name: "bob"}}
+	so.Authorizer = &server.ACL{Rules: []server.ACLRule{{Principals: []string{"alice"}, Methods: []string{"Arith.*"}}}}
+	so.AuditSink = server.AuditSinkFunc(func(event server.AuditEvent) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	})
+	addr := serve(t, newServer(t, so))
+	for _, tt := range []struct {
+		token client.StaticToken
+		code  protocol.StatusCode
+	}{
+		{"a", rpcerr.OK},
+		{"b", rpcerr.PermissionDenied},
+	} {
+		co := client.DefaultOption
+		co.Credentials = tt.token
+		c := newClient(t, addr, co)
+		err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &Reply{})
+		if rpcerr.Code(err) != tt.code {
+			t.Fatalf("token %s: got %v, want %s", tt.token, err, tt.code)
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(events) != 2 || events[0].Principal != "alice" || !events[0].Allowed || events[1].Principal != "bob" || events[1].Allowed {
+		t.Fatalf("audit events %+v", events)
+	}
+}
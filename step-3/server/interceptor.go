@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+)
+
+// UnaryInfo describes the call an interceptor wraps, the request metadata
+// is in the context under protocol.MetaDataKey.
+type UnaryInfo struct {
+	ServiceName string
+	MethodName  string
+}
+
+// UnaryHandler calls the rest of the chain and in the end the method, which fills in reply.
+type UnaryHandler func(ctx context.Context, arg interface{}, reply interface{}) error
+
+// UnaryInterceptor wraps every dispatched call. arg is the decoded argument as the method
+// takes it, reply the pointer the method fills in, and the returned error goes to the client.
+// An interceptor may call next with another context or not call it at all.
+type UnaryInterceptor func(ctx context.Context, info *UnaryInfo, arg interface{}, reply interface{}, next UnaryHandler) error
+
+// Use appends interceptors to the chain, the first one is outermost. Use must be called before Serve.
+func (s *simpleServer) Use(interceptors ...UnaryInterceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+func (s *simpleServer) chain(info *UnaryInfo, handler UnaryHandler) UnaryHandler {
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := s.interceptors[i], handler
+		handler = func(ctx context.Context, arg interface{}, reply interface{}) error {
+			return interceptor(ctx, info, arg, reply, next)
+		}
+	}
+	return handler
+}
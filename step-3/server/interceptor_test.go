@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+// chainLog records the interceptors a call passes in and out of.
+type chainLog struct {
+	mutex sync.Mutex
+	steps []string
+}
+
+func (tr *chainLog) interceptor(name string) server.UnaryInterceptor {
+	return func(ctx context.Context, info *server.UnaryInfo, arg interface{}, reply interface{}, next server.UnaryHandler) error {
+		tr.add(name + " " + info.ServiceName + "." + info.MethodName)
+		err := next(ctx, arg, reply)
+		tr.add(name + " out")
+		return err
+	}
+}
+
+func (tr *chainLog) add(step string) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.steps = append(tr.steps, step)
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	tr := new(chainLog)
+	s := newServer(t, server.DefaultOption)
+	s.Use(tr.interceptor("first"), tr.interceptor("second"))
+	c := newClient(t, serve(t, s), client.DefaultOption)
+	var reply Reply
+	if err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &reply); err != nil || reply.C != 3 {
+		t.Fatal(err, reply)
+	}
+	want := []string{"first Arith.Add", "second Arith.Add", "second out", "first out"}
+	if !reflect.DeepEqual(tr.steps, want) {
+		t.Fatalf("got %v, want %v", tr.steps, want)
+	}
+}
+
+func TestInterceptorShortCircuits(t *testing.T) {
+	tr := new(chainLog)
+	deny := func(ctx context.Context, info *server.UnaryInfo, arg interface{}, reply interface{}, next server.UnaryHandler) error {
+		reply.(*Reply).C = -1
+		return rpcerr.New(rpcerr.PermissionDenied, "denied")
+	}
+	s := newServer(t, server.DefaultOption)
+	s.Use(deny, tr.interceptor("inner"))
+	c := newClient(t, serve(t, s), client.DefaultOption)
+	reply := Reply{C: 7}
+	err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &reply)
+	if rpcerr.Code(err) != rpcerr.PermissionDenied {
+		t.Fatalf("got %v, want PermissionDenied", err)
+	}
+	// an error sends no reply
+	if reply.C != 7 {
+		t.Fatalf("reply %d, want it untouched", reply.C)
+	}
+	if len(tr.steps) != 0 {
+		t.Fatalf("the inner interceptor ran: %v", tr.steps)
+	}
+}
+
+func TestInterceptorSeesMethodError(t *testing.T) {
+	var seen error
+	s := newServer(t, server.DefaultOption)
+	s.Use(func(ctx context.Context, info *server.UnaryInfo, arg interface{}, reply interface{}, next server.UnaryHandler) error {
+		seen = next(ctx, arg, reply)
+		return seen
+	})
+	c := newClient(t, serve(t, s), client.DefaultOption)
+	err := c.Call(context.Background(), "Arith.NotFound", Args{}, &Reply{})
+	if rpcerr.Code(seen) != rpcerr.NotFound || rpcerr.Code(err) != rpcerr.NotFound {
+		t.Fatalf("interceptor saw %v, client got %v, want NotFound", seen, err)
+	}
+}
+
+func TestInterceptorWrongType(t *testing.T) {
+	s := newServer(t, server.DefaultOption)
+	s.Use(func(ctx context.Context, info *server.UnaryInfo, arg interface{}, reply interface{}, next server.UnaryHandler) error {
+		if info.MethodName == "Add" {
+			return next(ctx, &arg, reply)
+		}
+		return next(ctx, arg, Reply{})
+	})
+	c := newClient(t, serve(t, s), client.DefaultOption)
+	for _, method := range []string{"Arith.Add", "Arith.Sleep"} {
+		err := c.Call(context.Background(), method, Args{}, &Reply{})
+		if rpcerr.Code(err) != rpcerr.Internal {
+			t.Fatalf("%s: got %v, want Internal", method, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket of Rate requests per second with Burst tokens,
+// and at most MaxInFlight concurrent requests. Zero values disable either.
+type Limit struct {
+	Rate        float64
+	Burst       int
+	MaxInFlight int
+}
+
+func (l Limit) enabled() bool {
+	return l.Rate > 0 || l.MaxInFlight > 0
+}
+
+// Limits are checked per method ("Service.Method") and per service at dispatch, and per
+// client identity once the request is authenticated: the principal name, or else the remote host,
+// which is also the key of Clients.
+type Limits struct {
+	Methods  map[string]Limit
+	Services map[string]Limit
+	Clients  map[string]Limit
+	// PerClient applies to every client identity without an entry in Clients
+	PerClient Limit
+}
+
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(burst)
+	if b < 1 {
+		b = math.Max(1, rate)
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// take returns how long to wait for a token when there is none.
+func (b *tokenBucket) take() (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refund() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+type limitState struct {
+	limit    Limit
+	bucket   *tokenBucket
+	mutex    sync.Mutex
+	inflight int
+}
+
+func (l *limitState) acquire() (bool, time.Duration) {
+	if l.limit.MaxInFlight > 0 {
+		l.mutex.Lock()
+		if l.inflight >= l.limit.MaxInFlight {
+			l.mutex.Unlock()
+			return false, 0
+		}
+		l.inflight++
+		l.mutex.Unlock()
+	}
+	if l.bucket != nil {
+		if ok, wait := l.bucket.take(); !ok {
+			l.release()
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+func (l *limitState) release() {
+	if l.limit.MaxInFlight > 0 {
+		l.mutex.Lock()
+		l.inflight--
+		l.mutex.Unlock()
+	}
+}
+
+func newLimitState(limit Limit) *limitState {
+	state := &limitState{limit: limit}
+	if limit.Rate > 0 {
+		state.bucket = newTokenBucket(limit.Rate, limit.Burst)
+	}
+	return state
+}
+
+func (l *limitState) idle() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.inflight == 0
+}
+
+// clientIdleTTL is how long the state of an idle client is kept, and at least
+// until its token bucket is full again, when dropping it changes nothing.
+const clientIdleTTL = time.Minute
+
+type clientState struct {
+	*limitState
+	last time.Time
+}
+
+func (c *clientState) ttl() time.Duration {
+	ttl := clientIdleTTL
+	if c.bucket != nil {
+		if refill := time.Duration(c.bucket.burst / c.bucket.rate * float64(time.Second)); refill > ttl {
+			ttl = refill
+		}
+	}
+	return ttl
+}
+
+type limiter struct {
+	limits  Limits
+	states  sync.Map
+	mutex   sync.Mutex
+	clients map[string]*clientState
+	swept   time.Time
+}
+
+// state is only asked for configured methods and services, which bounds states.
+func (l *limiter) state(key string, limit Limit) *limitState {
+	if state, ok := l.states.Load(key); ok {
+		return state.(*limitState)
+	}
+	actual, _ := l.states.LoadOrStore(key, newLimitState(limit))
+	return actual.(*limitState)
+}
+
+func (l *limiter) clientState(client string, limit Limit) *limitState {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) >= clientIdleTTL {
+		l.sweep(now)
+	}
+	if l.clients == nil {
+		l.clients = make(map[string]*clientState)
+	}
+	state, ok := l.clients[client]
+	if !ok {
+		state = &clientState{limitState: newLimitState(limit)}
+		l.clients[client] = state
+	}
+	state.last = now
+	return state.limitState
+}
+
+// sweep must be called with l.mutex held.
+func (l *limiter) sweep(now time.Time) {
+	l.swept = now
+	for client, state := range l.clients {
+		if now.Sub(state.last) >= state.ttl() && state.idle() {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// admitClient takes a slot of the limit of a client identity, see admit.
+func (l *limiter) admitClient(client string) (release func(), retryAfter time.Duration, ok bool) {
+	limit, found := l.limits.Clients[client]
+	if !found {
+		limit = l.limits.PerClient
+	}
+	if !limit.enabled() {
+		return func() {}, 0, true
+	}
+	state := l.clientState(client, limit)
+	admitted, wait := state.acquire()
+	if !admitted {
+		return nil, wait, false
+	}
+	return state.release, 0, true
+}
+
+// admit takes a slot of the method and service limits of the request, release gives them
+// back once the request is done. A rejected request gets a retry-after hint, zero if unknown.
+func (l *limiter) admit(service, method string) (release func(), retryAfter time.Duration, ok bool) {
+	var acquired []*limitState
+	release = func() {
+		for _, state := range acquired {
+			state.release()
+		}
+	}
+	check := func(key string, limit Limit) bool {
+		if !limit.enabled() {
+			return true
+		}
+		state := l.state(key, limit)
+		admitted, wait := state.acquire()
+		if !admitted {
+			retryAfter = wait
+			return false
+		}
+		acquired = append(acquired, state)
+		return true
+	}
+	if !check("method/"+service+"."+method, l.limits.Methods[service+"."+method]) ||
+		!check("service/"+service, l.limits.Services[service]) {
+		for _, state := range acquired {
+			state.release()
+			if state.bucket != nil {
+				state.bucket.refund()
+			}
+		}
+		return nil, retryAfter, false
+	}
+	return release, 0, true
+}
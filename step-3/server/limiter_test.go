@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterEvictsIdleClients(t *testing.T) {
+	l := &limiter{limits: Limits{PerClient: Limit{Rate: 100, MaxInFlight: 10}}}
+	idle, _, _ := l.admitClient("idle")
+	idle()
+	busy, _, _ := l.admitClient("busy")
+	defer busy()
+	for _, state := range l.clients {
+		state.last = time.Now().Add(-2 * clientIdleTTL)
+	}
+	l.swept = time.Now().Add(-2 * clientIdleTTL)
+	l.admitClient("new")
+	if _, ok := l.clients["idle"]; ok {
+		t.Fatal("idle client is kept")
+	}
+	if _, ok := l.clients["busy"]; !ok {
+		t.Fatal("client with a request in flight is evicted")
+	}
+}
+
+func TestLimiterKeepsClientsUntilTheirBucketRefills(t *testing.T) {
+	l := &limiter{limits: Limits{PerClient: Limit{Rate: 0.001, Burst: 1}}}
+	l.admitClient("slow")
+	l.clients["slow"].last = time.Now().Add(-2 * clientIdleTTL)
+	l.swept = time.Now().Add(-2 * clientIdleTTL)
+	if _, _, ok := l.admitClient("slow"); ok {
+		t.Fatal("client got a second token")
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"github.com/huangw1/rpc-demo/step-3/metrics"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"time"
+)
+
+// serverMetrics is nil without Option.Metrics, every method is then a no-op.
+type serverMetrics struct {
+	requests     *metrics.CounterVec
+	latency      *metrics.HistogramVec
+	requestSize  *metrics.HistogramVec
+	responseSize *metrics.HistogramVec
+	inFlight     *metrics.GaugeVec
+	connections  *metrics.Gauge
+	known        func(service, method string) bool
+}
+
+func newServerMetrics(registry *metrics.Registry, known func(service, method string) bool) *serverMetrics {
+	if registry == nil {
+		return nil
+	}
+	return &serverMetrics{
+		known: known,
+		requests: registry.NewCounterVec("rpc_server_requests_total",
+			"Requests handled by the server, by status code.", "service", "method", "code"),
+		latency: registry.NewHistogramVec("rpc_server_request_duration_seconds",
+			"Time from reading a request to writing its response.", metrics.DefaultLatencyBuckets, "service", "method"),
+		requestSize: registry.NewHistogramVec("rpc_server_request_bytes",
+			"Payload size of requests.", metrics.DefaultSizeBuckets, "service", "method"),
+		responseSize: registry.NewHistogramVec("rpc_server_response_bytes",
+			"Payload size of responses.", metrics.DefaultSizeBuckets, "service", "method"),
+		inFlight: registry.NewGaugeVec("rpc_server_in_flight_requests",
+			"Requests being handled.", "service", "method"),
+		connections: registry.NewGaugeVec("rpc_server_connections",
+			"Open client connections.").With(),
+	}
+}
+
+func (m *serverMetrics) connOpened() {
+	if m != nil {
+		m.connections.Inc()
+	}
+}
+
+func (m *serverMetrics) connClosed() {
+	if m != nil {
+		m.connections.Dec()
+	}
+}
+
+// unknownLabel stands for the service and method of requests for methods the server does
+// not have, clients would otherwise create as many series as they like.
+const unknownLabel = "unknown"
+
+func (m *serverMetrics) labels(req *protocol.Message) []string {
+	if m.known(req.ServiceName, req.MethodName) {
+		return []string{req.ServiceName, req.MethodName}
+	}
+	return []string{unknownLabel, unknownLabel}
+}
+
+// begin returns the labels to end the request with, the method may be gone by then.
+func (m *serverMetrics) begin(req *protocol.Message) []string {
+	if m == nil {
+		return nil
+	}
+	labels := m.labels(req)
+	m.inFlight.With(labels...).Inc()
+	return labels
+}
+
+func (m *serverMetrics) end(labels []string, req *protocol.Message, res *protocol.Message, duration time.Duration) {
+	if m != nil {
+		m.inFlight.With(labels...).Dec()
+		m.record(labels, req, res, duration)
+	}
+}
+
+// observe records a request turned down before it began.
+func (m *serverMetrics) observe(req *protocol.Message, res *protocol.Message, duration time.Duration) {
+	if m != nil {
+		m.record(m.labels(req), req, res, duration)
+	}
+}
+
+// record records a request, res is nil when the client canceled it.
+func (m *serverMetrics) record(labels []string, req *protocol.Message, res *protocol.Message, duration time.Duration) {
+	code := rpcerr.Canceled
+	if res != nil {
+		code = res.StatusCode
+		m.responseSize.With(labels...).Observe(float64(len(res.Data)))
+	}
+	m.requests.With(labels[0], labels[1], code.String()).Inc()
+	m.latency.With(labels...).Observe(duration.Seconds())
+	m.requestSize.With(labels...).Observe(float64(len(req.Data)))
+}
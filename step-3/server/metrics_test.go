@@ -0,0 +1,38 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/metrics"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func TestMetricsLabelUnknownMethods(t *testing.T) {
+	registry := metrics.NewRegistry()
+	so := server.DefaultOption
+	so.Metrics = registry
+	addr := serve(t, newServer(t, so))
+	c := newClient(t, addr, client.DefaultOption)
+	c.Call(context.Background(), "Arith.Add", Args{}, &Reply{})
+	for _, serviceMethod := range []string{"Arith.Nope", "Nope.Add", "Random1.X", "Random2.Y"} {
+		c.Call(context.Background(), serviceMethod, Args{}, &Reply{})
+	}
+	var buf bytes.Buffer
+	registry.WriteTo(&buf)
+	text := buf.String()
+	for _, want := range []string{
+		`rpc_server_requests_total{service="Arith",method="Add",code="OK"} 1`,
+		`rpc_server_requests_total{service="unknown",method="unknown",code="Unimplemented"} 4`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("no %s in\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "Nope") || strings.Contains(text, "Random") {
+		t.Fatalf("series of unknown methods in\n%s", text)
+	}
+}
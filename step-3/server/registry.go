@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"net"
+	"time"
+)
+
+// advertiseAddr is Option.AdvertiseAddr, else the listen address with an unspecified
+// host, as of Serve("tcp", ":7000"), replaced by an address of this machine.
+func (s *simpleServer) advertiseAddr() (string, error) {
+	if s.option.AdvertiseAddr != "" {
+		return s.option.AdvertiseAddr, nil
+	}
+	addr := s.tr.Addr().String()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, nil
+	}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		return addr, nil
+	}
+	ip, err := interfaceIP()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// interfaceIP is the first global unicast address of the machine, ipv4 preferred.
+func interfaceIP() (net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var found net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ip := ipNet.IP.To4(); ip != nil {
+			return ip, nil
+		}
+		if found == nil {
+			found = ipNet.IP
+		}
+	}
+	if found == nil {
+		return nil, errors.New("rpc-server: no address to advertise, set Option.AdvertiseAddr")
+	}
+	return found, nil
+}
+
+func (s *simpleServer) instances() ([]registry.Instance, error) {
+	addr, err := s.advertiseAddr()
+	if err != nil {
+		return nil, err
+	}
+	var instances []registry.Instance
+	s.serviceMap.Range(func(key, value interface{}) bool {
+		instances = append(instances, registry.Instance{
+			Service:  key.(string),
+			Network:  s.network,
+			Addr:     addr,
+			MetaData: value.(*service).metaData,
+		})
+		return true
+	})
+	return instances, nil
+}
+
+func (s *simpleServer) register() {
+	instances, err := s.instances()
+	if err != nil {
+		s.logger.Error("rpc-server: fail to register", logging.KeyError, err)
+		return
+	}
+	for _, instance := range instances {
+		err := s.option.Registry.Register(instance, s.option.RegisterTTL)
+		if err != nil {
+			s.logger.Warn("rpc-server: fail to register", logging.KeyService, instance.Service, logging.KeyAddr, instance.Addr, logging.KeyError, err)
+		}
+	}
+}
+
+func (s *simpleServer) deregister() {
+	instances, err := s.instances()
+	if err != nil {
+		return
+	}
+	for _, instance := range instances {
+		err := s.option.Registry.Deregister(instance)
+		if err != nil {
+			s.logger.Warn("rpc-server: fail to deregister", logging.KeyService, instance.Service, logging.KeyAddr, instance.Addr, logging.KeyError, err)
+		}
+	}
+}
+
+// heartbeat registers the services and again well before the ttl runs out,
+// which also publishes services registered after Serve.
+func (s *simpleServer) heartbeat() {
+	defer s.heartbeats.Done()
+	s.register()
+	ticker := time.NewTicker(s.option.RegisterTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.register()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/registry"
+	"github.com/huangw1/rpc-demo/step-3/transport"
+)
+
+func TestAdvertiseAddr(t *testing.T) {
+	for _, tt := range []struct {
+		listen    string
+		advertise string
+	}{
+		{listen: "127.0.0.1:0"},
+		{listen: ":0"},
+		{listen: "[::]:0"},
+		{listen: ":0", advertise: "rpc.example.com:7000"},
+	} {
+		option := DefaultOption
+		option.AdvertiseAddr = tt.advertise
+		s := NewSimpleServer(option)
+		s.tr = transport.NewServerTransport(transport.TCPTransport)
+		if err := s.tr.Listen("tcp", tt.listen); err != nil {
+			t.Log(err)
+			continue
+		}
+		addr, err := s.advertiseAddr()
+		s.tr.Close()
+		if err != nil {
+			if _, ipErr := interfaceIP(); ipErr == nil {
+				t.Fatal(tt.listen, err)
+			}
+			continue
+		}
+		if tt.advertise != "" && addr != tt.advertise {
+			t.Fatalf("%s: advertised %s, want %s", tt.listen, addr, tt.advertise)
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			t.Fatalf("%s: advertised the unspecified address %s", tt.listen, addr)
+		}
+	}
+}
+
+type Echo struct{}
+
+func (Echo) Echo(ctx context.Context, arg string, reply *string) error {
+	*reply = arg
+	return nil
+}
+
+// slowRegistry blocks every Register until release is closed.
+type slowRegistry struct {
+	*registry.MemoryRegistry
+	registering chan struct{}
+	release     chan struct{}
+}
+
+func (r *slowRegistry) Register(instance registry.Instance, ttl time.Duration) error {
+	select {
+	case r.registering <- struct{}{}:
+	default:
+	}
+	<-r.release
+	return r.MemoryRegistry.Register(instance, ttl)
+}
+
+func TestCloseDeregistersAfterHeartbeat(t *testing.T) {
+	r := &slowRegistry{MemoryRegistry: registry.NewMemoryRegistry(), registering: make(chan struct{}, 1), release: make(chan struct{})}
+	defer r.Close()
+	option := DefaultOption
+	option.Registry = r
+	s := NewSimpleServer(option)
+	s.Register(Echo{}, nil)
+	go s.Serve("tcp", "127.0.0.1:0")
+	<-r.registering
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// Close does not hold the server lock while it waits for the registry
+	if s.Addr() == nil {
+		t.Fatal("no address while closing")
+	}
+	close(r.release)
+	<-closed
+	// give a register that outlived Close the time to publish a ghost
+	time.Sleep(20 * time.Millisecond)
+	if instances := r.Instances("Echo"); len(instances) != 0 {
+		t.Fatalf("%v still registered after Close", instances)
+	}
+}
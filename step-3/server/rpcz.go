@@ -0,0 +1,142 @@
+package server
+
+import (
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"github.com/huangw1/rpc-demo/step-3/rpcz"
+	"github.com/huangw1/rpc-demo/step-3/transport"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverRpcz is nil without Option.Rpcz, every method is then a no-op.
+type serverRpcz struct {
+	page   *rpcz.Page
+	s      *simpleServer
+	remove func()
+	name   atomic.Value
+	conns  sync.Map // transport.Transport -> time.Time
+	calls  sync.Map // *rpcz.Call -> struct{}
+}
+
+func newServerRpcz(page *rpcz.Page, s *simpleServer) *serverRpcz {
+	if page == nil {
+		return nil
+	}
+	r := &serverRpcz{page: page, s: s}
+	r.name.Store("server")
+	r.remove = page.Add(r)
+	return r
+}
+
+func (r *serverRpcz) listening(addr net.Addr) {
+	if r != nil {
+		r.name.Store("server " + addr.String())
+	}
+}
+
+func (r *serverRpcz) close() {
+	if r != nil {
+		r.remove()
+	}
+}
+
+func (r *serverRpcz) connOpened(tr transport.Transport) {
+	if r != nil {
+		r.conns.Store(tr, time.Now())
+	}
+}
+
+func (r *serverRpcz) connClosed(tr transport.Transport) {
+	if r != nil {
+		r.conns.Delete(tr)
+	}
+}
+
+func (r *serverRpcz) begin(tr transport.Transport, req *protocol.Message, start time.Time) *rpcz.Call {
+	if r == nil {
+		return nil
+	}
+	call := &rpcz.Call{
+		Seq:     req.Seq,
+		Service: req.ServiceName,
+		Method:  req.MethodName,
+		Addr:    tr.RemoteAddr().String(),
+		Start:   start,
+	}
+	r.calls.Store(call, struct{}{})
+	return call
+}
+
+// end records call with res, which is nil when the client canceled it.
+func (r *serverRpcz) end(call *rpcz.Call, res *protocol.Message) {
+	if r == nil {
+		return
+	}
+	r.calls.Delete(call)
+	done := *call
+	done.Duration = time.Since(call.Start)
+	code := rpcerr.Canceled
+	if res != nil {
+		code = res.StatusCode
+		done.Error = res.Error
+	}
+	done.Code = code.String()
+	r.page.Record(r.name.Load().(string), done, code != rpcerr.OK)
+}
+
+// observe records a request answered without being handled.
+func (r *serverRpcz) observe(tr transport.Transport, req *protocol.Message, res *protocol.Message, start time.Time) {
+	if r != nil {
+		r.end(r.begin(tr, req, start), res)
+	}
+}
+
+func (r *serverRpcz) Snapshot() rpcz.Snapshot {
+	now := time.Now()
+	snapshot := rpcz.Snapshot{Name: r.name.Load().(string)}
+	r.s.serviceMap.Range(func(key, value interface{}) bool {
+		service := value.(*service)
+		info := rpcz.Service{Name: service.name}
+		for name, method := range service.methods {
+			info.Methods = append(info.Methods, rpcz.Method{
+				Name:      name,
+				ArgType:   method.ArgType.String(),
+				ReplyType: method.ReplyType.String(),
+			})
+		}
+		sort.Slice(info.Methods, func(i, j int) bool {
+			return info.Methods[i].Name < info.Methods[j].Name
+		})
+		snapshot.Services = append(snapshot.Services, info)
+		return true
+	})
+	sort.Slice(snapshot.Services, func(i, j int) bool {
+		return snapshot.Services[i].Name < snapshot.Services[j].Name
+	})
+	r.conns.Range(func(key, value interface{}) bool {
+		tr := key.(transport.Transport)
+		snapshot.Conns = append(snapshot.Conns, rpcz.Conn{
+			LocalAddr:  tr.LocalAddr().String(),
+			RemoteAddr: tr.RemoteAddr().String(),
+			Since:      value.(time.Time),
+		})
+		return true
+	})
+	sort.Slice(snapshot.Conns, func(i, j int) bool {
+		return snapshot.Conns[i].Since.Before(snapshot.Conns[j].Since)
+	})
+	r.calls.Range(func(key, value interface{}) bool {
+		call := *key.(*rpcz.Call)
+		call.Duration = now.Sub(call.Start)
+		snapshot.Calls = append(snapshot.Calls, call)
+		return true
+	})
+	sort.Slice(snapshot.Calls, func(i, j int) bool {
+		return snapshot.Calls[i].Start.Before(snapshot.Calls[j].Start)
+	})
+	return snapshot
+}
@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/rpcz"
+	"github.com/huangw1/rpc-demo/step-3/server"
+)
+
+func TestRpcz(t *testing.T) {
+	page := rpcz.NewPage(10, time.Second)
+	so := server.DefaultOption
+	so.Rpcz = page
+	s := newServer(t, so)
+	addr := serve(t, s)
+	co := client.DefaultOption
+	co.Rpcz = page
+	c, err := client.NewSimpleClient("tcp", addr, co)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	call := c.Go(context.Background(), "Arith.Sleep", Args{A: 100}, &Reply{}, nil)
+	var snapshots []rpcz.Snapshot
+	waitFor(t, func() bool {
+		snapshots = page.Snapshots()
+		return len(snapshots) == 2 && len(snapshots[0].Calls) == 1
+	})
+	srv, cli := snapshots[0], snapshots[1]
+	if srv.Name != "server "+addr || cli.Name != "client "+addr {
+		t.Fatalf("sources %q and %q", srv.Name, cli.Name)
+	}
+	if len(srv.Services) != 1 || srv.Services[0].Name != "Arith" || len(srv.Services[0].Methods) != 3 {
+		t.Fatalf("services %+v", srv.Services)
+	}
+	if m := srv.Services[0].Methods[0]; m.Name != "Add" || m.ArgType != "server_test.Args" || m.ReplyType != "*server_test.Reply" {
+		t.Fatalf("method %+v", m)
+	}
+	if len(srv.Conns) != 1 || srv.Conns[0].LocalAddr != addr {
+		t.Fatalf("server connections %+v", srv.Conns)
+	}
+	if got := srv.Calls[0]; got.Service != "Arith" || got.Method != "Sleep" {
+		t.Fatalf("server call %+v", got)
+	}
+	if len(cli.Conns) != 1 || cli.Conns[0].RemoteAddr != addr || cli.Conns[0].State != "connected" {
+		t.Fatalf("client connections %+v", cli.Conns)
+	}
+	if len(cli.Calls) != 1 || cli.Calls[0].Method != "Sleep" {
+		t.Fatalf("client calls %+v", cli.Calls)
+	}
+	<-call.Done
+
+	// a failed call is kept on both sides
+	c.Call(context.Background(), "Arith.NotFound", Args{}, &Reply{})
+	waitFor(t, func() bool { return len(page.Recent()) == 2 })
+	for _, r := range page.Recent() {
+		if r.Method != "NotFound" || r.Code != "NotFound" {
+			t.Fatalf("recent %+v", r)
+		}
+	}
+
+	c.Close()
+	s.Close()
+	if snapshots := page.Snapshots(); len(snapshots) != 0 {
+		t.Fatalf("%d sources left after Close", len(snapshots))
+	}
+}
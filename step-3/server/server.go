@@ -10,14 +10,22 @@ import (
 	"unicode"
 	"errors"
 	"fmt"
+	"github.com/huangw1/rpc-demo/step-3/logging"
 	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
 	"io"
-	"log"
+	"net/http"
+	"bufio"
+	"time"
+	"net"
 )
 
 type RPCServer interface {
 	Register(receive interface{}, metaData map[string]string) error
 	Serve(network string, addr string) error
+	// Addr is the address Serve listens on, nil until it listens
+	Addr() net.Addr
+	Use(interceptors ...UnaryInterceptor)
 	Close() error
 }
 
@@ -28,12 +36,39 @@ type simpleServer struct {
 	mutex      sync.Mutex
 	shutdown   bool
 	option     Option
+	network    string
+	done       chan struct{}
+	heartbeats sync.WaitGroup
+	limiter    *limiter
+	nonces     *nonceCache
+	metrics    *serverMetrics
+	tracing    *serverTracing
+	logger     logging.Logger
+	rpcz       *serverRpcz
+
+	interceptors []UnaryInterceptor
 }
 
 func NewSimpleServer(option Option) *simpleServer {
 	s := new(simpleServer)
 	s.option = option
+	if s.option.RegisterTTL <= 0 {
+		s.option.RegisterTTL = DefaultOption.RegisterTTL
+	}
 	s.codec = codec.GetCodec(option.SerializeType)
+	s.done = make(chan struct{})
+	s.limiter = &limiter{limits: option.Limits}
+	if s.option.MaxClockSkew <= 0 {
+		s.option.MaxClockSkew = DefaultOption.MaxClockSkew
+	}
+	if s.option.NonceCacheSize <= 0 {
+		s.option.NonceCacheSize = DefaultOption.NonceCacheSize
+	}
+	s.nonces = newNonceCache(s.option.NonceCacheSize)
+	s.metrics = newServerMetrics(option.Metrics, s.hasMethod)
+	s.tracing = newServerTracing(option)
+	s.logger = logging.Or(option.Logger)
+	s.rpcz = newServerRpcz(option.Rpcz, s)
 	return s
 }
 
@@ -44,10 +79,11 @@ type methodType struct {
 }
 
 type service struct {
-	name    string
-	typ     reflect.Type
-	rcvr    reflect.Value
-	methods map[string]*methodType
+	name     string
+	typ      reflect.Type
+	rcvr     reflect.Value
+	methods  map[string]*methodType
+	metaData map[string]string
 }
 
 func (s *simpleServer) Register(rcvr interface{}, metaData map[string]string) error {
@@ -57,6 +93,7 @@ func (s *simpleServer) Register(rcvr interface{}, metaData map[string]string) er
 	service.name = name
 	service.typ = typ
 	service.rcvr = reflect.ValueOf(rcvr)
+	service.metaData = metaData
 	methods := suitableMethods(typ)
 	service.methods = methods
 	if len(service.methods) == 0 {
@@ -140,84 +177,368 @@ func isExported(name string) bool {
 	return unicode.IsUpper(r)
 }
 
+var ErrorServerClosed = errors.New("rpc-server: server closed")
+
 func (s *simpleServer) Serve(network string, addr string) error {
-	s.tr = transport.NewServerTransport(s.option.TransportType)
-	err := s.tr.Listen(network, addr)
+	ln := transport.NewServerTransport(s.option.TransportType)
+	if ws, ok := ln.(*transport.ServerWebSocket); ok {
+		ws.AllowedOrigins = s.option.AllowedOrigins
+	}
+	err := ln.Listen(network, addr)
 	if err != nil {
 		return err
 	}
+	// Close reads s.tr under the mutex
+	s.mutex.Lock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		ln.Close()
+		return ErrorServerClosed
+	}
+	s.tr = ln
+	s.network = network
+	if s.option.Registry != nil {
+		// added under the mutex, so Close waits for it before it deregisters
+		s.heartbeats.Add(1)
+		go s.heartbeat()
+	}
+	s.mutex.Unlock()
+	s.rpcz.listening(ln.Addr())
 	for {
-		tr, err := s.tr.Accept()
+		tr, err := ln.Accept()
 		if err != nil {
 			return err
 		}
 		go s.serveTransport(tr)
 	}
-	return err
+}
+
+func (s *simpleServer) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.tr == nil {
+		return nil
+	}
+	return s.tr.Addr()
+}
+
+// ServeHTTP hijacks CONNECT requests and serves the rpc protocol on the
+// connection, so the server can be mounted on an existing http mux.
+func (s *simpleServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "CONNECT" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		s.logger.Error("rpc-server: hijacking", logging.KeyRemoteAddr, req.RemoteAddr, logging.KeyError, err)
+		return
+	}
+	io.WriteString(conn, "HTTP/1.0 "+protocol.HTTPConnected+"\n\n")
+	s.serveTransport(transport.NewSocket(conn))
+}
+
+// HandleHTTP registers the server on http.DefaultServeMux at rpcPath.
+func (s *simpleServer) HandleHTTP(rpcPath string) {
+	http.Handle(rpcPath, s)
 }
 
 func (s *simpleServer) serveTransport(tr transport.Transport) {
+	var cancels sync.Map
+	conn := &connState{tr: tr, idleTimeout: s.option.IdleTimeout, maxInFlight: s.option.MaxConcurrentRequests}
+	defer func() {
+		tr.Close()
+		cancels.Range(func(key, value interface{}) bool {
+			value.(context.CancelFunc)()
+			return true
+		})
+	}()
+	s.metrics.connOpened()
+	defer s.metrics.connClosed()
+	s.rpcz.connOpened(tr)
+	defer s.rpcz.connClosed(tr)
+	tr.SetKeepAlive(s.option.KeepAlivePeriod)
+	r := bufio.NewReader(tr)
 	for {
-		req, err := protocol.DecodeMessage(s.option.ProtocolType, tr)
+		req, err := s.readRequest(tr, r, conn)
 		if err != nil {
-			fmt.Println(err)
 			if err == io.EOF {
-				log.Println("rpc-server: client has closed connection")
-			} else {
-				log.Println("rpc-server: fail to read request")
+				s.logger.Debug("rpc-server: client has closed connection", logging.KeyRemoteAddr, tr.RemoteAddr())
+			} else if !transport.IsTimeout(err) {
+				s.logger.Warn("rpc-server: fail to read request", logging.KeyRemoteAddr, tr.RemoteAddr(), logging.KeyError, err)
 			}
 			return
-		} else {
-			res := req.Clone()
-			res.MessageType = protocol.MessageTypeRes
-			serviceName := res.ServiceName
-			methodName := res.MethodName
-			serviceVal, ok := s.serviceMap.Load(serviceName)
-			if !ok {
-				log.Printf("rpc-server: can not find service %s", serviceName)
-				return
+		}
+		if req.MessageType == protocol.MessageTypeCancel {
+			if cancel, ok := cancels.Load(req.Seq); ok {
+				cancel.(context.CancelFunc)()
 			}
-			service, ok := serviceVal.(*service)
+			continue
+		}
+		start := time.Now()
+		ctx, span := s.tracing.start(context.Background(), req, tr.RemoteAddr())
+		// the connection cap goes first, a request it rejects takes no tokens of the limits
+		var release func()
+		var retryAfter time.Duration
+		ok := conn.begin()
+		if ok {
+			release, retryAfter, ok = s.limiter.admit(req.ServiceName, req.MethodName)
 			if !ok {
-				log.Println("rpc-server: not *service type")
-				return
+				conn.end()
 			}
-			method := service.methods[methodName]
-			ctx := context.Background()
-			arg := newVal(method.ArgType)
-			reply := newVal(method.ReplyType)
-			err = codec.GetCodec(s.option.SerializeType).Decode(res.Data, arg)
-			var returns []reflect.Value
-			if method.ArgType.Kind() != reflect.Ptr {
-				returns = method.method.Func.Call([]reflect.Value{
-					service.rcvr,
-					reflect.ValueOf(ctx),
-					reflect.ValueOf(arg).Elem(),
-					reflect.ValueOf(reply),
-				})
-			} else {
-				returns = method.method.Func.Call([]reflect.Value{
-					service.rcvr,
-					reflect.ValueOf(ctx),
-					reflect.ValueOf(arg),
-					reflect.ValueOf(reply),
-				})
+		}
+		if !ok {
+			res := s.resourceExhausted(req, retryAfter)
+			s.writeMessage(tr, res)
+			s.metrics.observe(req, res, time.Since(start))
+			s.tracing.end(span, res)
+			s.accessLog(tr, req, res, time.Since(start))
+			s.rpcz.observe(tr, req, res, start)
+			continue
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		cancels.Store(req.Seq, cancel)
+		labels := s.metrics.begin(req)
+		call := s.rpcz.begin(tr, req, start)
+		go func() {
+			res := s.handleRequest(ctx, tr, req)
+			if res != nil {
+				s.writeMessage(tr, res)
 			}
-			if len(returns) > 0 && returns[0].Interface() != nil {
-				err := returns[0].Interface().(error)
-				s.writeErrorResponse(res, tr, err.Error())
-				return
+			s.metrics.end(labels, req, res, time.Since(start))
+			s.tracing.end(span, res)
+			s.accessLog(tr, req, res, time.Since(start))
+			s.rpcz.end(call, res)
+			release()
+			cancels.Delete(req.Seq)
+			cancel()
+			conn.end()
+		}()
+	}
+}
+
+// handleRequest returns the response to write, nil when there is none.
+func (s *simpleServer) handleRequest(ctx context.Context, tr transport.Transport, req *protocol.Message) *protocol.Message {
+	res := req.Clone()
+	res.MessageType = protocol.MessageTypeRes
+	if req.MetaData != nil {
+		ctx = context.WithValue(ctx, protocol.MetaDataKey, req.MetaData)
+	}
+	if len(s.option.SigningKeys) > 0 {
+		if err := s.verifySignature(req); err != nil {
+			return s.errorResponse(res, rpcerr.Unauthenticated, err)
+		}
+	}
+	if s.option.Authenticator != nil {
+		var err error
+		ctx, err = s.authenticate(ctx, req)
+		if err != nil {
+			return s.errorResponse(res, rpcerr.Unauthenticated, err)
+		}
+	}
+	release, retryAfter, ok := s.limiter.admitClient(clientIdentity(ctx, tr))
+	if !ok {
+		return s.resourceExhausted(req, retryAfter)
+	}
+	defer release()
+	if s.option.Authorizer != nil {
+		if err := s.authorize(ctx, tr.RemoteAddr(), req); err != nil {
+			return s.errorResponse(res, rpcerr.PermissionDenied, err)
+		}
+	}
+	serviceName := res.ServiceName
+	methodName := res.MethodName
+	serviceVal, ok := s.serviceMap.Load(serviceName)
+	if !ok {
+		s.logger.Warn("rpc-server: can not find service", logging.KeyRemoteAddr, tr.RemoteAddr(),
+			logging.KeySeq, req.Seq, logging.KeyService, serviceName)
+		return s.errorResponse(res, rpcerr.Unimplemented, errors.New("rpc-server: can not find service "+serviceName))
+	}
+	service, ok := serviceVal.(*service)
+	if !ok {
+		s.logger.Error("rpc-server: not *service type", logging.KeyService, serviceName)
+		return nil
+	}
+	method, ok := service.methods[methodName]
+	if !ok {
+		return s.errorResponse(res, rpcerr.Unimplemented, errors.New("rpc-server: can not find method "+serviceName+"."+methodName))
+	}
+	if timeout, err := time.ParseDuration(req.MetaData[protocol.RequestTimeoutKey]); err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	arg := newVal(method.ArgType)
+	reply := newVal(method.ReplyType)
+	err := codec.GetCodec(s.option.SerializeType).Decode(res.Data, arg)
+	if err != nil {
+		return s.errorResponse(res, rpcerr.InvalidArgument, errors.New("rpc-server: reading body "+err.Error()))
+	}
+	if method.ArgType.Kind() != reflect.Ptr {
+		arg = reflect.ValueOf(arg).Elem().Interface()
+	}
+	info := &UnaryInfo{ServiceName: serviceName, MethodName: methodName}
+	err = s.chain(info, service.call(method))(ctx, arg, reply)
+	if ctx.Err() == context.Canceled {
+		// the client has given up on this call
+		return nil
+	}
+	if err != nil {
+		return s.errorResponse(res, rpcerr.Unknown, err)
+	}
+	data, err := codec.GetCodec(s.option.SerializeType).Encode(reply)
+	if err != nil {
+		return s.errorResponse(res, rpcerr.Internal, err)
+	}
+	res.StatusCode = protocol.StatusOk
+	res.Data = data
+	return res
+}
+
+func (s *simpleServer) hasMethod(serviceName, methodName string) bool {
+	serviceVal, ok := s.serviceMap.Load(serviceName)
+	if !ok {
+		return false
+	}
+	_, ok = serviceVal.(*service).methods[methodName]
+	return ok
+}
+
+// call is the end of the interceptor chain. An interceptor may have replaced arg or reply,
+// they are checked against the method so a wrong type fails the call instead of panicking.
+func (s *service) call(method *methodType) UnaryHandler {
+	return func(ctx context.Context, arg interface{}, reply interface{}) error {
+		values := []interface{}{ctx, arg, reply}
+		in := []reflect.Value{s.rcvr}
+		for i, typ := range []reflect.Type{typeOfContext, method.ArgType, method.ReplyType} {
+			v := reflect.ValueOf(values[i])
+			if !v.IsValid() || !v.Type().AssignableTo(typ) {
+				return rpcerr.New(rpcerr.Internal, fmt.Sprintf("rpc-server: %s.%s takes %s, not %T",
+					s.name, method.method.Name, typ, values[i]))
 			}
-			data, err := codec.GetCodec(s.option.SerializeType).Encode(reply)
-			if err != nil {
-				s.writeErrorResponse(res, tr, err.Error())
-				return
+			in = append(in, v)
+		}
+		returns := method.method.Func.Call(in)
+		if err, ok := returns[0].Interface().(error); ok && err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+// connState counts the requests in flight on a connection. The idle deadline is only armed
+// while the connection waits for a request with none in flight: a transport is never read
+// again once it timed out, a timed out websocket fails every later read.
+type connState struct {
+	mutex       sync.Mutex
+	tr          transport.Transport
+	idleTimeout time.Duration
+	maxInFlight int
+	inflight    int
+	waiting     bool
+}
+
+func (c *connState) wait() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.waiting = true
+	if c.inflight == 0 {
+		c.tr.SetReadDeadline(deadline(c.idleTimeout))
+	} else {
+		c.tr.SetReadDeadline(time.Time{})
+	}
+}
+
+func (c *connState) read(timeout time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.waiting = false
+	c.tr.SetReadDeadline(deadline(timeout))
+}
+
+// begin reports false when maxInFlight requests are already in flight.
+func (c *connState) begin() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.maxInFlight > 0 && c.inflight >= c.maxInFlight {
+		return false
+	}
+	c.inflight++
+	return true
+}
+
+// end arms the idle deadline when the last request in flight ends while the connection waits.
+func (c *connState) end() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.inflight--
+	if c.inflight == 0 && c.waiting {
+		c.tr.SetReadDeadline(deadline(c.idleTimeout))
+	}
+}
+
+// readRequest waits at most IdleTimeout for the next request to start, a connection
+// with requests in flight is not idle, and then at most ReadTimeout for the rest of it.
+func (s *simpleServer) readRequest(tr transport.Transport, r *bufio.Reader, conn *connState) (*protocol.Message, error) {
+	if s.option.IdleTimeout > 0 || s.option.ReadTimeout > 0 {
+		conn.wait()
+		_, err := r.Peek(1)
+		if err != nil {
+			if transport.IsTimeout(err) {
+				s.logger.Info("rpc-server: closing idle connection", logging.KeyRemoteAddr, tr.RemoteAddr(), "idle_timeout", s.option.IdleTimeout)
 			}
-			res.StatusCode = protocol.StatusOk
-			res.Data =data
-			tr.Write(protocol.EncodeMessage(s.option.ProtocolType, res))
+			return nil, err
 		}
+		conn.read(s.option.ReadTimeout)
+	}
+	req, err := protocol.DecodeMessage(s.option.ProtocolType, r)
+	if err != nil && transport.IsTimeout(err) {
+		s.logger.Warn("rpc-server: closing connection, request not read in time", logging.KeyRemoteAddr, tr.RemoteAddr(), "read_timeout", s.option.ReadTimeout)
+	}
+	return req, err
+}
+
+func (s *simpleServer) writeMessage(tr transport.Transport, res *protocol.Message) {
+	if s.option.WriteTimeout > 0 {
+		tr.SetWriteDeadline(time.Now().Add(s.option.WriteTimeout))
+	}
+	_, err := tr.Write(protocol.EncodeMessage(s.option.ProtocolType, res))
+	if err != nil {
+		s.logger.Warn("rpc-server: fail to write response", logging.KeyRemoteAddr, tr.RemoteAddr(),
+			logging.KeySeq, res.Seq, logging.KeyService, res.ServiceName, logging.KeyMethod, res.MethodName, logging.KeyError, err)
+	}
+}
+
+// accessLog logs a request as Option.AccessLog samples it, res is nil when the client canceled it.
+func (s *simpleServer) accessLog(tr transport.Transport, req *protocol.Message, res *protocol.Message, duration time.Duration) {
+	code := rpcerr.Canceled
+	if res != nil {
+		code = res.StatusCode
 	}
+	if !s.option.AccessLog.Sample(code != rpcerr.OK, duration) {
+		return
+	}
+	args := []interface{}{
+		logging.KeyRemoteAddr, tr.RemoteAddr(),
+		logging.KeySeq, req.Seq,
+		logging.KeyService, req.ServiceName,
+		logging.KeyMethod, req.MethodName,
+		logging.KeyCode, code.String(),
+		logging.KeyDuration, duration,
+	}
+	if res != nil && code != rpcerr.OK {
+		args = append(args, logging.KeyError, res.Error)
+	}
+	s.logger.Info("rpc-server: access", args...)
+}
+
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
 }
 
 func newVal(t reflect.Type) interface{} {
@@ -228,18 +549,68 @@ func newVal(t reflect.Type) interface{} {
 	}
 }
 
-func (s *simpleServer) writeErrorResponse(res *protocol.Message, w io.Writer, err string) {
-	res.Error = err
+// errorResponse answers with the code and details of a typed err, see rpcerr, or code otherwise.
+func (s *simpleServer) errorResponse(res *protocol.Message, code protocol.StatusCode, err error) *protocol.Message {
+	e, ok := rpcerr.FromError(err)
+	if !ok {
+		e = rpcerr.New(code, err.Error())
+	}
+	if e.Code == rpcerr.OK {
+		// an error is never a success, whatever its code says
+		e = &rpcerr.Error{Code: code, Message: e.Message, Details: e.Details}
+	}
+	res.Error = e.Message
+	res.ErrorDetails = e.Details
 	res.Data = res.Data[:0]
-	res.StatusCode = protocol.StatusError
-	w.Write(protocol.EncodeMessage(s.option.ProtocolType, res))
+	res.StatusCode = e.Code
+	return res
+}
+
+func (s *simpleServer) resourceExhausted(req *protocol.Message, retryAfter time.Duration) *protocol.Message {
+	res := req.Clone()
+	res.MessageType = protocol.MessageTypeRes
+	msg := "rpc-server: resource exhausted for " + req.ServiceName + "." + req.MethodName
+	err := rpcerr.New(rpcerr.ResourceExhausted, msg)
+	if retryAfter > 0 {
+		err = rpcerr.New(rpcerr.ResourceExhausted, msg, rpcerr.RetryInfo{RetryDelay: retryAfter})
+	}
+	return s.errorResponse(res, rpcerr.ResourceExhausted, err)
 }
 
+// clientIdentity is the authenticated principal, else the remote host,
+// never what the client claims to be.
+func clientIdentity(ctx context.Context, tr transport.Transport) string {
+	if principal, ok := PrincipalFromContext(ctx); ok && principal != nil {
+		return principal.Name
+	}
+	host, _, err := net.SplitHostPort(tr.RemoteAddr().String())
+	if err != nil {
+		return tr.RemoteAddr().String()
+	}
+	return host
+}
+
+// Close stops the heartbeat and waits for it, so no register in flight publishes the
+// services again, then deregisters them without holding s.mutex.
 func (s *simpleServer) Close() error {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		return nil
+	}
 	s.shutdown = true
-	err := s.tr.Close()
+	close(s.done)
+	tr := s.tr
+	s.mutex.Unlock()
+	s.rpcz.close()
+	s.heartbeats.Wait()
+	if s.option.Registry != nil && tr != nil {
+		s.deregister()
+	}
+	var err error
+	if tr != nil {
+		err = tr.Close()
+	}
 	s.serviceMap.Range(func(key, value interface{}) bool {
 		s.serviceMap.Delete(key)
 		return true
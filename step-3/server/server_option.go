@@ -1,10 +1,17 @@
 package server
 
 import (
+	"github.com/huangw1/rpc-demo/step-3/logging"
+	"github.com/huangw1/rpc-demo/step-3/metrics"
 	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcz"
 	"github.com/huangw1/rpc-demo/step-3/codec"
 	"github.com/huangw1/rpc-demo/step-3/transport"
 	"time"
+	"github.com/huangw1/rpc-demo/step-3/registry"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Option struct {
@@ -12,8 +19,59 @@ type Option struct {
 	SerializeType codec.SerializeType
 	CompressType protocol.CompressType
 	TransportType transport.TransportType
+	// AllowedOrigins lists the origins of the browser pages, other than the host of the server,
+	// that may connect over the WebSocketTransport, e.g. "https://app.example.com", "*" for any
+	AllowedOrigins []string
 
 	RequestTimeout time.Duration
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// KeepAlivePeriod zero keeps the system default, negative disables tcp keepalive
+	KeepAlivePeriod time.Duration
+
+	// Registry, when set, gets every service on Serve and is refreshed every RegisterTTL/3
+	Registry    registry.Registry
+	RegisterTTL time.Duration
+	// AdvertiseAddr is published instead of the listen address, e.g. behind nat. Without it a
+	// listen address like ":7000" is published with the first address of the machine
+	AdvertiseAddr string
+
+	// MaxConcurrentRequests handled at once on a connection, requests beyond
+	// it are rejected with rpcerr.ResourceExhausted, zero is unlimited
+	MaxConcurrentRequests int
+
+	// Limits reject requests at dispatch with rpcerr.ResourceExhausted
+	Limits Limits
+
+	// Authenticator checks the bearer token of every request, nil accepts every request
+	Authenticator Authenticator
+	// Authorizer, e.g. a FileACL, decides every request after authentication, AuditSink gets every decision
+	Authorizer Authorizer
+	AuditSink  AuditSink
+
+	// SigningKeys by key id make the server reject requests without a valid hmac signature,
+	// older or newer than MaxClockSkew, or with a nonce among the last NonceCacheSize seen
+	SigningKeys    map[string][]byte
+	MaxClockSkew   time.Duration
+	NonceCacheSize int
+
+	// Metrics records requests, latencies, payload sizes and connections, e.g. in metrics.DefaultRegistry
+	Metrics *metrics.Registry
+
+	// TracerProvider, otel.GetTracerProvider() by default, starts a span for every request as a child
+	// of the trace context Propagator finds in the metadata, w3c traceparent and tracestate by default
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
+
+	// Logger is slog.Default() when nil, AccessLog logs every request through it as it samples them
+	Logger    logging.Logger
+	AccessLog logging.AccessLog
+
+	// Rpcz lists the services, connections, in-flight and recent slow or failed requests
+	// on its debug page, e.g. rpcz.DefaultPage
+	Rpcz *rpcz.Page
 }
 
 var DefaultOption = Option{
@@ -22,4 +80,7 @@ var DefaultOption = Option{
 	CompressType: protocol.CompressTypeNone,
 	TransportType: transport.TCPTransport,
 	RequestTimeout: time.Second * 60,
+	RegisterTTL: time.Second * 10,
+	MaxClockSkew: time.Minute * 5,
+	NonceCacheSize: 100000,
 }
\ No newline at end of file
@@ -0,0 +1,202 @@
+package server_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"github.com/huangw1/rpc-demo/step-3/server"
+	"github.com/huangw1/rpc-demo/step-3/transport"
+)
+
+type Arith struct{}
+
+type Args struct{ A, B int }
+
+type Reply struct{ C int }
+
+func (Arith) Add(ctx context.Context, args Args, reply *Reply) error {
+	reply.C = args.A + args.B
+	return nil
+}
+
+// Sleep sleeps args.A milliseconds.
+func (Arith) Sleep(ctx context.Context, args Args, reply *Reply) error {
+	time.Sleep(time.Duration(args.A) * time.Millisecond)
+	return nil
+}
+
+func (Arith) NotFound(ctx context.Context, args Args, reply *Reply) error {
+	return rpcerr.New(rpcerr.NotFound, "")
+}
+
+// serve starts s on a free local port and returns its address once it listens.
+func serve(t *testing.T, s server.RPCServer) string {
+	t.Helper()
+	errs := make(chan error, 1)
+	go func() { errs <- s.Serve("tcp", "127.0.0.1:0") }()
+	t.Cleanup(func() { s.Close() })
+	for s.Addr() == nil {
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return s.Addr().String()
+}
+
+// waitFor polls cond for up to a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
+
+func newServer(t *testing.T, option server.Option) server.RPCServer {
+	t.Helper()
+	s := server.NewSimpleServer(option)
+	if err := s.Register(Arith{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newClient(t *testing.T, addr string, option client.Option) client.RPCClient {
+	t.Helper()
+	c, err := client.NewSimpleClient("tcp", addr, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestIdleTimeoutWithRequestInFlight(t *testing.T) {
+	for _, tt := range []transport.TransportType{transport.TCPTransport, transport.WebSocketTransport} {
+		so := server.DefaultOption
+		so.TransportType = tt
+		so.IdleTimeout = 50 * time.Millisecond
+		addr := serve(t, newServer(t, so))
+		co := client.DefaultOption
+		co.TransportType = tt
+		c := newClient(t, addr, co)
+		for i := 0; i < 2; i++ {
+			if err := c.Call(context.Background(), "Arith.Sleep", Args{A: 300}, &Reply{}); err != nil {
+				t.Fatalf("transport %d: %v", tt, err)
+			}
+		}
+	}
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	so := server.DefaultOption
+	so.MaxConcurrentRequests = 2
+	addr := serve(t, newServer(t, so))
+	c := newClient(t, addr, client.DefaultOption)
+	var calls []*client.Call
+	for i := 0; i < 4; i++ {
+		calls = append(calls, c.Go(context.Background(), "Arith.Sleep", Args{A: 200}, &Reply{}, nil))
+	}
+	rejected := 0
+	for _, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			if !client.Rejected(call.Error) {
+				t.Fatal(call.Error)
+			}
+			rejected++
+		}
+	}
+	if rejected != 2 {
+		t.Fatalf("rejected %d of 4 requests, want 2", rejected)
+	}
+}
+
+func TestPerClientLimit(t *testing.T) {
+	so := server.DefaultOption
+	so.Authenticator = server.TokenAuthenticator{"a": {Name: "alice"}, "b": {Name: "bob"}}
+	so.Limits = server.Limits{PerClient: server.Limit{Rate: 0.01, Burst: 2}}
+	addr := serve(t, newServer(t, so))
+	for _, token := range []client.StaticToken{"a", "b"} {
+		co := client.DefaultOption
+		co.Credentials = token
+		c := newClient(t, addr, co)
+		rejected := 0
+		for i := 0; i < 5; i++ {
+			// the client id a client claims is not its identity
+			ctx := context.WithValue(context.Background(), protocol.MetaDataKey,
+				map[string]string{"rpc_client_id": strconv.Itoa(i)})
+			err := c.Call(ctx, "Arith.Add", Args{}, &Reply{})
+			if client.Rejected(err) {
+				rejected++
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if rejected != 3 {
+			t.Fatalf("token %s: rejected %d of 5 requests, want 3", token, rejected)
+		}
+	}
+}
+
+func TestErrorWithoutMessage(t *testing.T) {
+	addr := serve(t, newServer(t, server.DefaultOption))
+	c := newClient(t, addr, client.DefaultOption)
+	err := c.Call(context.Background(), "Arith.NotFound", Args{}, &Reply{})
+	if rpcerr.Code(err) != rpcerr.NotFound {
+		t.Fatalf("got %v (%s), want NotFound", err, rpcerr.Code(err))
+	}
+}
+
+func TestSigning(t *testing.T) {
+	so := server.DefaultOption
+	so.SigningKeys = map[string][]byte{"k": []byte("secret")}
+	addr := serve(t, newServer(t, so))
+	for _, tt := range []struct {
+		key  *client.SigningKey
+		code protocol.StatusCode
+	}{
+		{&client.SigningKey{ID: "k", Secret: []byte("secret")}, rpcerr.OK},
+		{&client.SigningKey{ID: "k", Secret: []byte("guess")}, rpcerr.Unauthenticated},
+		{nil, rpcerr.Unauthenticated},
+	} {
+		co := client.DefaultOption
+		co.SigningKey = tt.key
+		c := newClient(t, addr, co)
+		for i := 0; i < 2; i++ {
+			err := c.Call(context.Background(), "Arith.Add", Args{A: 1, B: 2}, &Reply{})
+			if rpcerr.Code(err) != tt.code {
+				t.Fatalf("key %+v: got %v, want %s", tt.key, err, tt.code)
+			}
+		}
+	}
+}
+
+func TestConnectionCapTakesNoTokens(t *testing.T) {
+	so := server.DefaultOption
+	so.MaxConcurrentRequests = 1
+	so.Limits = server.Limits{Methods: map[string]server.Limit{"Arith.Sleep": {Rate: 0.01, Burst: 2}}}
+	addr := serve(t, newServer(t, so))
+	c := newClient(t, addr, client.DefaultOption)
+	var calls []*client.Call
+	for i := 0; i < 3; i++ {
+		calls = append(calls, c.Go(context.Background(), "Arith.Sleep", Args{A: 100}, &Reply{}, nil))
+	}
+	for _, call := range calls {
+		<-call.Done
+	}
+	// the requests the connection cap rejected left the second token
+	if err := c.Call(context.Background(), "Arith.Sleep", Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/hmac"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrorBadSignature = rpcerr.New(rpcerr.Unauthenticated, "rpc-server: bad request signature")
+var ErrorSignatureExpired = rpcerr.New(rpcerr.Unauthenticated, "rpc-server: request signature outside the clock skew window")
+var ErrorReplayed = rpcerr.New(rpcerr.Unauthenticated, "rpc-server: request nonce already seen")
+
+// verifySignature checks the hmac of a request signed with one of SigningKeys, its timestamp
+// against MaxClockSkew and its nonce against the ones seen before.
+func (s *simpleServer) verifySignature(req *protocol.Message) error {
+	keyID := req.MetaData[protocol.SignatureKeyIDKey]
+	key, ok := s.option.SigningKeys[keyID]
+	if !ok {
+		return ErrorBadSignature
+	}
+	timestamp, err := strconv.ParseInt(req.MetaData[protocol.SignatureTimestampKey], 10, 64)
+	if err != nil {
+		return ErrorBadSignature
+	}
+	skew := time.Since(time.Unix(0, timestamp))
+	if skew > s.option.MaxClockSkew || skew < -s.option.MaxClockSkew {
+		return ErrorSignatureExpired
+	}
+	signature := protocol.Signature(req, key)
+	if !hmac.Equal([]byte(signature), []byte(req.MetaData[protocol.SignatureKey])) {
+		return ErrorBadSignature
+	}
+	nonce := req.MetaData[protocol.SignatureNonceKey]
+	if nonce == "" || !s.nonces.add(keyID+"/"+nonce) {
+		return ErrorReplayed
+	}
+	return nil
+}
+
+// nonceCache remembers the last size nonces, the oldest is forgotten first.
+// It must hold every nonce seen within twice MaxClockSkew for full replay protection.
+type nonceCache struct {
+	mutex sync.Mutex
+	size  int
+	seen  map[string]struct{}
+	order []string
+	next  int
+}
+
+func newNonceCache(size int) *nonceCache {
+	return &nonceCache{size: size, seen: make(map[string]struct{}, size)}
+}
+
+// add reports whether nonce is new.
+func (c *nonceCache) add(nonce string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	if len(c.order) < c.size {
+		c.order = append(c.order, nonce)
+	} else {
+		delete(c.seen, c.order[c.next])
+		c.order[c.next] = nonce
+		c.next = (c.next + 1) % c.size
+	}
+	c.seen[nonce] = struct{}{}
+	return true
+}
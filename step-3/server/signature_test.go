@@ -0,0 +1,73 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+)
+
+// signed returns a request signed with secret under key id "k" at t with nonce.
+func signed(secret string, t time.Time, nonce string) *protocol.Message {
+	req := &protocol.Message{Header: &protocol.Header{Seq: 1, ServiceName: "Arith", MethodName: "Add"}, Data: []byte("args")}
+	req.MetaData = map[string]string{
+		protocol.SignatureKeyIDKey:     "k",
+		protocol.SignatureTimestampKey: strconv.FormatInt(t.UnixNano(), 10),
+		protocol.SignatureNonceKey:     nonce,
+	}
+	req.MetaData[protocol.SignatureKey] = protocol.Signature(req, []byte(secret))
+	return req
+}
+
+func TestVerifySignature(t *testing.T) {
+	option := DefaultOption
+	option.SigningKeys = map[string][]byte{"k": []byte("secret")}
+	option.MaxClockSkew = time.Minute
+	s := NewSimpleServer(option)
+	now := time.Now()
+
+	if err := s.verifySignature(signed("secret", now, "1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verifySignature(signed("secret", now, "1")); err != ErrorReplayed {
+		t.Fatalf("replayed nonce: got %v, want %v", err, ErrorReplayed)
+	}
+	if err := s.verifySignature(signed("other", now, "2")); err != ErrorBadSignature {
+		t.Fatalf("wrong secret: got %v, want %v", err, ErrorBadSignature)
+	}
+	tampered := signed("secret", now, "3")
+	tampered.Data = []byte("other args")
+	if err := s.verifySignature(tampered); err != ErrorBadSignature {
+		t.Fatalf("tampered data: got %v, want %v", err, ErrorBadSignature)
+	}
+	unknown := signed("secret", now, "4")
+	unknown.MetaData[protocol.SignatureKeyIDKey] = "unknown"
+	if err := s.verifySignature(unknown); err != ErrorBadSignature {
+		t.Fatalf("unknown key id: got %v, want %v", err, ErrorBadSignature)
+	}
+	for _, at := range []time.Time{now.Add(-2 * time.Minute), now.Add(2 * time.Minute)} {
+		if err := s.verifySignature(signed("secret", at, "5")); err != ErrorSignatureExpired {
+			t.Fatalf("signed at %s: got %v, want %v", at.Sub(now), err, ErrorSignatureExpired)
+		}
+	}
+	// rejected requests do not use up their nonce
+	if err := s.verifySignature(signed("secret", now, "5")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNonceCacheForgetsTheOldest(t *testing.T) {
+	c := newNonceCache(2)
+	for _, nonce := range []string{"a", "b", "c"} {
+		if !c.add(nonce) {
+			t.Fatalf("nonce %s is new", nonce)
+		}
+	}
+	if c.add("c") || c.add("b") {
+		t.Fatal("the last 2 nonces are remembered")
+	}
+	if !c.add("a") {
+		t.Fatal("the oldest nonce is forgotten")
+	}
+}
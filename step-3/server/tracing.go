@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"github.com/huangw1/rpc-demo/step-3/protocol"
+	"github.com/huangw1/rpc-demo/step-3/rpcerr"
+	"net"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/huangw1/rpc-demo/step-3/server"
+
+type serverTracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func newServerTracing(option Option) *serverTracing {
+	provider := option.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	propagator := option.Propagator
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+	return &serverTracing{tracer: provider.Tracer(instrumentationName), propagator: propagator}
+}
+
+// start opens the span of req as a child of the trace context in its metadata.
+func (t *serverTracing) start(ctx context.Context, req *protocol.Message, remoteAddr net.Addr) (context.Context, trace.Span) {
+	ctx = t.propagator.Extract(ctx, propagation.MapCarrier(req.MetaData))
+	return t.tracer.Start(ctx, req.ServiceName+"/"+req.MethodName,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "rpc-demo"),
+			attribute.String("rpc.service", req.ServiceName),
+			attribute.String("rpc.method", req.MethodName),
+			attribute.String("client.address", remoteAddr.String()),
+		))
+}
+
+// end closes span with the status of res, nil when the client canceled the request.
+func (t *serverTracing) end(span trace.Span, res *protocol.Message) {
+	code := rpcerr.Canceled
+	if res != nil {
+		code = res.StatusCode
+	}
+	span.SetAttributes(attribute.String("rpc.status_code", code.String()))
+	if code != rpcerr.OK {
+		message := code.String()
+		if res != nil {
+			message = res.Error
+		}
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
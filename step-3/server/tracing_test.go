@@ -0,0 +1,68 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/huangw1/rpc-demo/step-3/client"
+	"github.com/huangw1/rpc-demo/step-3/server"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+	so := server.DefaultOption
+	so.TracerProvider = provider
+	addr := serve(t, newServer(t, so))
+	co := client.DefaultOption
+	co.TracerProvider = provider
+	c := newClient(t, addr, co)
+
+	for _, method := range []string{"Add", "NotFound"} {
+		exporter.Reset()
+		c.Call(context.Background(), "Arith."+method, Args{A: 1, B: 2}, &Reply{})
+		waitFor(t, func() bool { return len(exporter.GetSpans()) == 2 })
+		spans := make(map[trace.SpanKind]tracetest.SpanStub)
+		for _, span := range exporter.GetSpans() {
+			spans[span.SpanKind] = span
+		}
+		clientSpan, serverSpan := spans[trace.SpanKindClient], spans[trace.SpanKindServer]
+		for _, span := range []tracetest.SpanStub{clientSpan, serverSpan} {
+			if span.Name != "Arith/"+method {
+				t.Fatalf("span %q, want Arith/%s", span.Name, method)
+			}
+			if !hasAttribute(span, attribute.String("rpc.method", method)) {
+				t.Fatalf("span %s has no rpc.method, attributes %v", span.Name, span.Attributes)
+			}
+		}
+		if serverSpan.Parent.SpanID() != clientSpan.SpanContext.SpanID() ||
+			serverSpan.SpanContext.TraceID() != clientSpan.SpanContext.TraceID() {
+			t.Fatalf("server span %v is not a child of client span %v", serverSpan.Parent, clientSpan.SpanContext)
+		}
+		code, status := "OK", codes.Unset
+		if method == "NotFound" {
+			code, status = "NotFound", codes.Error
+		}
+		for _, span := range []tracetest.SpanStub{clientSpan, serverSpan} {
+			if !hasAttribute(span, attribute.String("rpc.status_code", code)) || span.Status.Code != status {
+				t.Fatalf("span %s: status %v, attributes %v, want %s", span.Name, span.Status, span.Attributes, code)
+			}
+		}
+	}
+}
+
+func hasAttribute(span tracetest.SpanStub, kv attribute.KeyValue) bool {
+	for _, attr := range span.Attributes {
+		if attr == kv {
+			return true
+		}
+	}
+	return false
+}
@@ -1,25 +1,38 @@
 package transport
 
 import (
+	"context"
 	"io"
 	"net"
+	"time"
 )
 
 type TransportType byte
 
 const (
-	TCPTransport = iota
+	TCPTransport TransportType = iota
+	WebSocketTransport
 )
 
-var transports = map[TransportType]Transport{
-	TCPTransport: &Socket{},
+var transports = map[TransportType]func() Transport{
+	TCPTransport:       func() Transport { return &Socket{} },
+	WebSocketTransport: func() Transport { return &WebSocket{} },
 }
 
+// DialFunc is a custom dialer hook, e.g. for proxies or source address binding.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
 type Transport interface {
 	Dial(network, addr string) error
+	// DialContext dials with net.Dialer when dial is nil
+	DialContext(ctx context.Context, network, addr string, dial DialFunc) error
 	io.ReadWriteCloser
 	LocalAddr() net.Addr
 	RemoteAddr() net.Addr
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	// SetKeepAlive keeps the system default for zero period and disables tcp keepalive for negative one
+	SetKeepAlive(period time.Duration) error
 }
 
 type Socket struct {
@@ -27,11 +40,22 @@ type Socket struct {
 }
 
 func NewTransport(t TransportType) Transport {
-	return transports[t]
+	return transports[t]()
+}
+
+func NewSocket(conn net.Conn) *Socket {
+	return &Socket{conn: conn}
 }
 
 func (s *Socket) Dial(network, addr string) error {
-	conn, err := net.Dial(network, addr)
+	return s.DialContext(context.Background(), network, addr, nil)
+}
+
+func (s *Socket) DialContext(ctx context.Context, network, addr string, dial DialFunc) error {
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	conn, err := dial(ctx, network, addr)
 	s.conn = conn
 	return err
 }
@@ -56,13 +80,47 @@ func (s *Socket) RemoteAddr() net.Addr {
 	return s.conn.RemoteAddr()
 }
 
-var serverTransports = map[TransportType]ServerTransport{
-	TCPTransport: &ServerSocket{},
+func (s *Socket) SetReadDeadline(t time.Time) error {
+	return s.conn.SetReadDeadline(t)
+}
+
+func (s *Socket) SetWriteDeadline(t time.Time) error {
+	return s.conn.SetWriteDeadline(t)
+}
+
+func (s *Socket) SetKeepAlive(period time.Duration) error {
+	return setKeepAlive(s.conn, period)
+}
+
+func setKeepAlive(conn net.Conn, period time.Duration) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || period == 0 {
+		return nil
+	}
+	if period < 0 {
+		return tcpConn.SetKeepAlive(false)
+	}
+	err := tcpConn.SetKeepAlive(true)
+	if err != nil {
+		return err
+	}
+	return tcpConn.SetKeepAlivePeriod(period)
+}
+
+func IsTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+var serverTransports = map[TransportType]func() ServerTransport{
+	TCPTransport:       func() ServerTransport { return &ServerSocket{} },
+	WebSocketTransport: func() ServerTransport { return &ServerWebSocket{Path: DefaultWebSocketPath} },
 }
 
 type ServerTransport interface {
 	Listen(network, addr string) error
 	Accept() (Transport, error)
+	Addr() net.Addr
 	io.Closer
 }
 
@@ -71,7 +129,7 @@ type ServerSocket struct {
 }
 
 func NewServerTransport(t TransportType) ServerTransport {
-	return serverTransports[t]
+	return serverTransports[t]()
 }
 
 func (s *ServerSocket) Listen(network, addr string) error {
@@ -85,6 +143,10 @@ func (s *ServerSocket) Accept() (Transport, error) {
 	return &Socket{conn: conn}, err
 }
 
+func (s *ServerSocket) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
 func (s *ServerSocket) Close() error {
 	return s.ln.Close()
 }
@@ -0,0 +1,229 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Every rpc frame is carried by exactly one binary message. Addr is either
+// "host:port", served on DefaultWebSocketPath, or a full "ws://host:port/path" url.
+const DefaultWebSocketPath = "/_rpc_"
+
+// closeTimeout bounds sending the close message to a peer that does not read.
+const closeTimeout = time.Second
+
+type WebSocket struct {
+	conn   *websocket.Conn
+	reader io.Reader
+	wmutex sync.Mutex
+}
+
+func (w *WebSocket) Dial(network, addr string) error {
+	return w.DialContext(context.Background(), network, addr, nil)
+}
+
+func (w *WebSocket) DialContext(ctx context.Context, network, addr string, dial DialFunc) error {
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.NetDialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	}
+	conn, _, err := dialer.DialContext(ctx, webSocketURL(addr), nil)
+	w.conn = conn
+	return err
+}
+
+func (w *WebSocket) Read(bytes []byte) (int, error) {
+	for {
+		if w.reader == nil {
+			messageType, r, err := w.conn.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					err = io.EOF
+				}
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			w.reader = r
+		}
+		n, err := w.reader.Read(bytes)
+		if err == io.EOF {
+			w.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (w *WebSocket) Write(bytes []byte) (int, error) {
+	w.wmutex.Lock()
+	defer w.wmutex.Unlock()
+	err := w.conn.WriteMessage(websocket.BinaryMessage, bytes)
+	if err != nil {
+		return 0, err
+	}
+	return len(bytes), nil
+}
+
+// Close sends the close message without waiting for a blocked Write longer than closeTimeout.
+func (w *WebSocket) Close() error {
+	w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(closeTimeout))
+	return w.conn.Close()
+}
+
+func (w *WebSocket) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+func (w *WebSocket) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+func (w *WebSocket) SetReadDeadline(t time.Time) error {
+	return w.conn.SetReadDeadline(t)
+}
+
+func (w *WebSocket) SetWriteDeadline(t time.Time) error {
+	w.wmutex.Lock()
+	defer w.wmutex.Unlock()
+	return w.conn.SetWriteDeadline(t)
+}
+
+func (w *WebSocket) SetKeepAlive(period time.Duration) error {
+	return setKeepAlive(w.conn.UnderlyingConn(), period)
+}
+
+func webSocketURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	return "ws://" + addr + DefaultWebSocketPath
+}
+
+// ServerWebSocket accepts websocket connections on Path. Browsers send an Origin header, and
+// unless Upgrader.CheckOrigin is set, only pages served from the same host as the rpc server or
+// from one of AllowedOrigins, e.g. "https://app.example.com" or "*" for any, may connect.
+// Clients outside a browser send no Origin and are always accepted.
+type ServerWebSocket struct {
+	Path           string
+	Upgrader       websocket.Upgrader
+	AllowedOrigins []string
+
+	server    *http.Server
+	ln        net.Listener
+	conns     chan Transport
+	done      chan struct{}
+	initOnce  sync.Once
+	closeOnce sync.Once
+}
+
+func (s *ServerWebSocket) init() {
+	s.initOnce.Do(func() {
+		s.conns = make(chan Transport)
+		s.done = make(chan struct{})
+	})
+}
+
+func (s *ServerWebSocket) Listen(network, addr string) error {
+	s.init()
+	path := s.Path
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return err
+		}
+		addr = u.Host
+		if u.Path != "" {
+			path = u.Path
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	mux := http.NewServeMux()
+	mux.Handle(path, s)
+	s.server = &http.Server{Handler: mux}
+	go s.server.Serve(ln)
+	return nil
+}
+
+// ServeHTTP upgrades the request and hands the connection to Accept,
+// so the transport can also be mounted on an existing mux.
+func (s *ServerWebSocket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.init()
+	upgrader := s.Upgrader
+	if upgrader.CheckOrigin == nil {
+		upgrader.CheckOrigin = s.checkOrigin
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	select {
+	case s.conns <- &WebSocket{conn: conn}:
+	case <-s.done:
+		conn.Close()
+	}
+}
+
+// checkOrigin is the same host check of the default Upgrader, extended by AllowedOrigins.
+func (s *ServerWebSocket) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ServerWebSocket) Accept() (Transport, error) {
+	s.init()
+	select {
+	case conn := <-s.conns:
+		return conn, nil
+	case <-s.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (s *ServerWebSocket) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *ServerWebSocket) Close() error {
+	s.init()
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.server != nil {
+			err = s.server.Close()
+		}
+	})
+	return err
+}
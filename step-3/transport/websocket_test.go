@@ -0,0 +1,90 @@
+package transport_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/huangw1/rpc-demo/step-3/transport"
+)
+
+func TestWebSocketAllowedOrigins(t *testing.T) {
+	s := &transport.ServerWebSocket{Path: transport.DefaultWebSocketPath, AllowedOrigins: []string{"https://app.example.com"}}
+	if err := s.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go func() {
+		for {
+			conn, err := s.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := s.Addr().String()
+	for _, tt := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://" + addr, true},
+		{"https://app.example.com", true},
+		{"https://evil.example.com", false},
+	} {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+transport.DefaultWebSocketPath, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("origin %q: got %v, want accepted %t", tt.origin, err, tt.ok)
+		}
+	}
+}
+
+func TestWebSocketCloseWithWriteBlocked(t *testing.T) {
+	s := &transport.ServerWebSocket{Path: transport.DefaultWebSocketPath}
+	if err := s.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// the client never reads
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+s.Addr().String()+transport.DefaultWebSocketPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tr, err := s.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error, 1)
+	go func() {
+		data := make([]byte, 1<<20)
+		for {
+			if _, err := tr.Write(data); err != nil {
+				written <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- tr.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Close blocked behind a Write")
+	}
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("Write still blocked after Close")
+	}
+}